@@ -18,6 +18,7 @@ var (
 	from      = flag.String("from", "", "the currency to convert from (3-letter symbol)")
 	to        = flag.String("to", "", "the currency to convert to (3-letter symbol)")
 	tolerance = flag.Int("tolerance", 0, "how many days before the specified date to search for the forex rate")
+	lookahead = flag.Int("lookahead", 0, "how many days after the specified date to search for the forex rate (the nearest day wins)")
 	verbose   = flag.Bool("v", false, "print more info, mainly the conversion trace")
 	offline   = flag.Bool("offline", false, "don't connect to the internet, use only offline data")
 	date      = flag.String("date", "today", "effective date as YYYY-MM-DD, or aliases 'today' and 'yesterday'")
@@ -55,6 +56,7 @@ func getTolerance() int {
 func getOpts() []exchange.Option {
 	opts := []exchange.Option{
 		exchange.AcceptOlderRate(getTolerance()),
+		exchange.AcceptNewerRate(*lookahead),
 	}
 
 	opts = append(opts, exchange.FullTrace)
@@ -80,12 +82,13 @@ func flagUsage(f *flag.Flag) {
 
 func printUsage() {
 	fmt.Fprint(flag.CommandLine.Output(), "Usage: forex-convert -from FROM -to TO")
-	fmt.Fprint(flag.CommandLine.Output(), " [-date YYYY-MM-DD] [-tolerance TOLERANCE] [-offline] [-v]\n")
+	fmt.Fprint(flag.CommandLine.Output(), " [-date YYYY-MM-DD] [-tolerance TOLERANCE] [-lookahead LOOKAHEAD] [-offline] [-v]\n")
 	fmt.Fprint(flag.CommandLine.Output(), "Options:\n")
 	flagUsage(flag.Lookup("from"))
 	flagUsage(flag.Lookup("to"))
 	flagUsage(flag.Lookup("date"))
 	flagUsage(flag.Lookup("tolerance"))
+	flagUsage(flag.Lookup("lookahead"))
 	flagUsage(flag.Lookup("offline"))
 	flagUsage(flag.Lookup("v"))
 }
@@ -117,6 +120,9 @@ func main() {
 	}
 	t = t.UTC().Truncate(24 * time.Hour)
 	e := getExchange()
+	if *debug {
+		e.Logger = log.Default()
+	}
 
 	src, err := getCurrency(*from)
 	if err != nil {
@@ -132,13 +138,20 @@ func main() {
 	if *debug {
 		log.Printf("Cache dir=%s lifetime=%v", e.CacheDir, e.CacheLife)
 		log.Printf("Using exchange %v", e)
+		for _, st := range e.Status() {
+			log.Printf("Source %s: %v, %d rates from %s to %s (error: %v)", st.Name, st.Origin, st.Rates,
+				st.FirstDay.Format("2006-01-02"), st.LastDay.Format("2006-01-02"), st.Err)
+		}
 	}
 	if err != nil {
 		log.Fatalf("Convert: %v", err)
 	}
 
 	for _, step := range rate.Trace {
-		if !step.Day.Equal(t) {
+		if step.Day.After(t) {
+			log.Printf("Warning: rate %s to %s is from a later day, dated %s (wanted %s, -lookahead=%d)",
+				step.From, step.To, step.Day.Format("2006-01-02"), t.Format("2006-01-02"), *lookahead)
+		} else if !step.Day.Equal(t) {
 			log.Printf("Warning: rate %s to %s is stale, dated %s (wanted %s, -tolerance=%d)",
 				step.From, step.To, step.Day.Format("2006-01-02"), t.Format("2006-01-02"), getTolerance())
 		}
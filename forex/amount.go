@@ -0,0 +1,198 @@
+package forex
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/wowsignal-io/go-forex/forex/exchange"
+)
+
+// Amount is an amount of money in a currency, as an exact decimal number:
+// Units × 10^-Scale. For example, 12.34 USD is {"USD", 1234, 2}.
+type Amount struct {
+	Currency string
+	Units    int64
+	Scale    int
+}
+
+// ParseAmount parses a decimal number, such as "-1234.5", as an amount of the
+// currency.
+func ParseAmount(currency, s string) (Amount, error) {
+	digits := s
+	if strings.HasPrefix(digits, "-") || strings.HasPrefix(digits, "+") {
+		digits = digits[1:]
+	}
+	whole, frac := digits, ""
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		whole, frac = digits[:i], digits[i+1:]
+	}
+	if whole == "" && frac == "" || strings.Trim(whole+frac, "0123456789") != "" {
+		return Amount{}, fmt.Errorf("invalid amount %q", s)
+	}
+
+	var units big.Int
+	if _, ok := units.SetString(strings.Replace(s, ".", "", 1), 10); !ok || !units.IsInt64() {
+		return Amount{}, fmt.Errorf("invalid amount %q", s)
+	}
+	return Amount{Currency: currency, Units: units.Int64(), Scale: len(frac)}, nil
+}
+
+// Rat returns the amount as a fraction.
+func (a Amount) Rat() *big.Rat {
+	r := new(big.Rat).SetInt64(a.Units)
+	return r.Quo(r, new(big.Rat).SetInt(pow10(a.Scale)))
+}
+
+// Float64 returns the nearest float64 to the amount.
+func (a Amount) Float64() float64 {
+	f, _ := a.Rat().Float64()
+	return f
+}
+
+// String formats the amount with all its decimal places, e.g. "12.30 USD".
+func (a Amount) String() string {
+	return fmt.Sprintf("%s %s", a.Rat().FloatString(a.Scale), a.Currency)
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// minorUnits lists the currencies whose minor unit isn't a hundredth, with the
+// number of decimal places from ISO 4217.
+var minorUnits = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0,
+	"KRW": 0, "PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0,
+	"XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLF": 4, "UYW": 4,
+}
+
+// MinorUnits returns the number of decimal places of the currency's minor unit,
+// according to ISO 4217: e.g. 2 for USD (cents), 0 for JPY and 3 for KWD.
+// Currencies without a minor unit in ISO 4217, such as XDR, get 2.
+func MinorUnits(currency string) int {
+	if n, ok := minorUnits[currency]; ok {
+		return n
+	}
+	return 2
+}
+
+// RoundingMode specifies how ConvertAmount rounds to the minor unit.
+//
+// The default value is RoundHalfUp.
+type RoundingMode int8
+
+const (
+	// Round to the nearest, and halves away from zero. (Commercial rounding.)
+	RoundHalfUp RoundingMode = iota
+	// Round to the nearest, and halves to the even neighbour. (Banker's
+	// rounding.)
+	RoundHalfEven
+	// Round to the nearest, and halves towards zero.
+	RoundHalfDown
+	// Truncate.
+	RoundTowardZero
+	// Round any fraction away from zero.
+	RoundAwayFromZero
+)
+
+func (m RoundingMode) String() string {
+	switch m {
+	case RoundHalfUp:
+		return "RoundHalfUp"
+	case RoundHalfEven:
+		return "RoundHalfEven"
+	case RoundHalfDown:
+		return "RoundHalfDown"
+	case RoundTowardZero:
+		return "RoundTowardZero"
+	case RoundAwayFromZero:
+		return "RoundAwayFromZero"
+	default:
+		return "<invalid RoundingMode>"
+	}
+}
+
+// Round rounds x to the given number of decimal places.
+func (m RoundingMode) Round(x *big.Rat, scale int) (*big.Int, error) {
+	num := new(big.Int).Mul(x.Num(), pow10(scale))
+	den := x.Denom()
+	q, r := new(big.Int).QuoRem(num, den, new(big.Int))
+	if r.Sign() == 0 {
+		return q, nil
+	}
+
+	// Compare the remainder to half of the denominator.
+	half := new(big.Int).Abs(r)
+	half.Lsh(half, 1)
+	cmp := half.Cmp(den)
+
+	var away bool
+	switch m {
+	case RoundHalfUp:
+		away = cmp >= 0
+	case RoundHalfEven:
+		away = cmp > 0 || cmp == 0 && q.Bit(0) == 1
+	case RoundHalfDown:
+		away = cmp > 0
+	case RoundTowardZero:
+		away = false
+	case RoundAwayFromZero:
+		away = true
+	default:
+		return nil, fmt.Errorf("invalid rounding mode %d", m)
+	}
+	if away {
+		q.Add(q, big.NewInt(int64(r.Sign())))
+	}
+	return q, nil
+}
+
+// AmountResult is the result of ConvertAmount.
+type AmountResult struct {
+	// The converted amount, rounded to the minor unit of its currency.
+	Amount Amount
+	// The rate used for the conversion, and its trace (with FullTrace).
+	exchange.Result
+}
+
+// ConvertAmount converts the amount to the to currency on the given date, and
+// rounds the result to the currency's minor unit (see MinorUnits) using the
+// rounding mode. The options are the same as for Convert. The conversion uses
+// ExactArithmetic, so the published rates are applied without float rounding
+// errors.
+func (e *Exchange) ConvertAmount(amount Amount, to string, date time.Time, rounding RoundingMode, opts ...exchange.Option) (AmountResult, error) {
+	return e.ConvertAmountContext(context.Background(), amount, to, date, rounding, opts...)
+}
+
+// ConvertAmountContext is like ConvertAmount, but any download required to
+// answer the query is bound to ctx.
+func (e *Exchange) ConvertAmountContext(ctx context.Context, amount Amount, to string, date time.Time, rounding RoundingMode, opts ...exchange.Option) (AmountResult, error) {
+	opts = append(opts[:len(opts):len(opts)], exchange.ExactArithmetic)
+	res, err := e.ConvertContext(ctx, amount.Currency, to, date, opts...)
+	if err != nil {
+		return AmountResult{}, err
+	}
+
+	// Consensus rates aren't exact.
+	rate := res.Exact
+	if rate == nil {
+		if rate = new(big.Rat).SetFloat64(res.Rate); rate == nil {
+			return AmountResult{}, fmt.Errorf("invalid rate %v", res.Rate)
+		}
+	}
+
+	scale := MinorUnits(to)
+	units, err := rounding.Round(new(big.Rat).Mul(rate, amount.Rat()), scale)
+	if err != nil {
+		return AmountResult{}, err
+	}
+	if !units.IsInt64() {
+		return AmountResult{}, fmt.Errorf("converting %v to %s: amount out of range", amount, to)
+	}
+	return AmountResult{Amount: Amount{Currency: to, Units: units.Int64(), Scale: scale}, Result: res}, nil
+}
@@ -7,10 +7,12 @@ package boc
 
 import (
 	"bytes"
+	"context"
 	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"strconv"
 	"strings"
 	"time"
@@ -26,7 +28,32 @@ func Get(uri string) ([]exchange.Rate, error) {
 	if err != nil {
 		return nil, err
 	}
+	return Source{}.Parse(raw)
+}
+
+// Source provides the BOC rates to forex.Exchange. The zero value downloads
+// from DefaultBOCSource.
+type Source struct {
+	// Where to download the CSV from, if not DefaultBOCSource. Can also be a
+	// file path or a data URL.
+	URL string
+}
+
+func (Source) Name() string { return "BOC" }
+
+func (Source) Attribution() string { return "Bank of Canada" }
+
+// Fetch downloads all rates since the start date in the URL. (The default is
+// January 3, 2017.)
+func (s Source) Fetch(ctx context.Context, client *http.Client, start, end time.Time) ([]byte, error) {
+	uri := s.URL
+	if uri == "" {
+		uri = DefaultBOCSource
+	}
+	return internal.FetchContext(ctx, uri, internal.WithClient(client))
+}
 
+func (Source) Parse(raw []byte) ([]exchange.Rate, error) {
 	needle := []byte("\"OBSERVATIONS\"")
 	i := bytes.Index(raw, needle)
 	if i < 0 {
@@ -75,11 +102,12 @@ func parse(r io.Reader) ([]exchange.Rate, error) {
 			}
 
 			result = append(result, exchange.Rate{
-				From: currency,
-				Day:  t,
-				To:   "CAD",
-				Rate: rate,
-				Info: "BOC",
+				From:    currency,
+				Day:     t,
+				To:      "CAD",
+				Rate:    rate,
+				Info:    "BOC",
+				Decimal: value,
 			})
 		}
 	}
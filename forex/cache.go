@@ -0,0 +1,135 @@
+package forex
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Cache stores the raw data downloaded from each source of exchange rates, so
+// that the Exchange doesn't need to download it again until it goes stale.
+// Entries are keyed by the source name passed to AddSource.
+//
+// Two implementations are provided: DirCache stores the data in files, and
+// MemoryCache keeps it in memory. Implementations must be safe for concurrent
+// use.
+type Cache interface {
+	// Get returns the data last stored under key. If there is none, the error
+	// satisfies errors.Is(err, fs.ErrNotExist).
+	Get(key string) ([]byte, error)
+	// Put replaces the data stored under key. The replacement must be atomic:
+	// a concurrent Get returns either the old or the new data in full.
+	Put(key string, data []byte) error
+	// ModTime returns when the data under key was last replaced. If there is
+	// no data, ModTime returns the zero time and no error.
+	ModTime(key string) (time.Time, error)
+}
+
+var pathFriendlyChars = regexp.MustCompile(`[^a-zA-Z0-9]`)
+
+// DirCache is a Cache that stores each entry as a file in the named directory.
+// The directory is created on the first Put.
+type DirCache string
+
+func (d DirCache) path(key string) string {
+	return filepath.Join(string(d), "forex_"+pathFriendlyChars.ReplaceAllString(key, "_")+"_cache")
+}
+
+// Get implements Cache.
+func (d DirCache) Get(key string) ([]byte, error) {
+	return os.ReadFile(d.path(key))
+}
+
+// Put implements Cache. The data is written to a temporary file in the same
+// directory, which is then renamed over the entry. The rename is atomic, so
+// concurrent readers (including other processes) see either the old or the new
+// file, never a partial one.
+func (d DirCache) Put(key string, data []byte) error {
+	// Ignore the error here - whether or not this worked, the thing that
+	// matters is the os.CreateTemp call.
+	os.MkdirAll(string(d), 0740)
+
+	path := d.path(key)
+	f, err := os.CreateTemp(string(d), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// After a successful rename, this fails harmlessly.
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	// After Sync, Close has no reason to return error, but strange things do
+	// happen.
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// ModTime implements Cache.
+func (d DirCache) ModTime(key string) (time.Time, error) {
+	st, err := os.Stat(d.path(key))
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return st.ModTime(), nil
+}
+
+// MemoryCache is a Cache that keeps all entries in memory. It's useful on
+// read-only filesystems and in tests. The zero value is an empty cache ready to
+// use.
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	data    []byte
+	modTime time.Time
+}
+
+// Get implements Cache.
+func (m *MemoryCache) Get(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, fmt.Errorf("memory cache entry %q: %w", key, fs.ErrNotExist)
+	}
+	return e.data, nil
+}
+
+// Put implements Cache. The cache keeps a reference to data, which the caller
+// must not modify afterwards.
+func (m *MemoryCache) Put(key string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.entries == nil {
+		m.entries = map[string]memoryEntry{}
+	}
+	m.entries[key] = memoryEntry{data: data, modTime: time.Now()}
+	return nil
+}
+
+// ModTime implements Cache.
+func (m *MemoryCache) ModTime(key string) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.entries[key].modTime, nil
+}
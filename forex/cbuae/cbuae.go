@@ -3,18 +3,25 @@
 // Historical rates are published monthly as excel spreadsheets. Daily rates are
 // available as HTML from a fairly convenient URL.
 //
-// At the moment, we don't implement historical rates - instead, we just grab
-// the last three days of daily rates.
+// The CBUAE doesn't publish a single file with its full history. Instead, the
+// Source fetches the daily pages for the days of the last year (see
+// Source.HistoryDays) that the Exchange is missing, and merges them into the
+// data it already has. That way, the history accumulates for as long as the
+// cache is kept. Older history can be loaded from the monthly spreadsheets,
+// which have to be downloaded from the CBUAE website and listed in
+// Source.Spreadsheets.
 package cbuae
 
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	_ "embed"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/wowsignal-io/go-forex/forex/exchange"
@@ -28,7 +35,8 @@ var currenciesTxt string
 var currencyNamesTxt string
 
 func DownloadOption(req *http.Request, client *http.Client) *http.Request {
-	req2, err := http.NewRequest("POST", req.URL.String(), nil)
+	// Keep the original context, so the caller can still cancel the download.
+	req2, err := http.NewRequestWithContext(req.Context(), "POST", req.URL.String(), nil)
 	if err != nil {
 		panic(err)
 	}
@@ -81,6 +89,174 @@ func Get(uri string) ([]exchange.Rate, error) {
 	return parse(raw)
 }
 
+// DefaultHistoryDays is how far back the daily pages are fetched, unless
+// Source.HistoryDays says otherwise.
+const DefaultHistoryDays = 366
+
+// How many daily pages are downloaded at the same time.
+const parallelDownloads = 8
+
+// Source provides the CBUAE rates to forex.Exchange.
+//
+// Source implements forex.GapFillingSource: it fetches the daily pages for the
+// business days missing from the cache, and returns their rates in a simple
+// CSV format, which Merge combines with the cached rates.
+type Source struct {
+	// Where to download a single daily page from, instead of the pages for each
+	// missing business day. Can also be a file path or a data URL.
+	URL string
+	// How many days back the daily pages are fetched, if they're missing from
+	// the cache. If zero, DefaultHistoryDays.
+	HistoryDays int
+	// URLs or file paths of monthly spreadsheets with historical rates, older
+	// than the daily pages. They are loaded together with the first daily
+	// pages (or later, if the cache has no older rates), and then kept in the
+	// cache.
+	Spreadsheets []string
+}
+
+func (Source) Name() string { return "CBUAE" }
+
+func (Source) Attribution() string { return "Central Bank of the U.A.E." }
+
+func (s Source) historyDays() int {
+	if s.HistoryDays > 0 {
+		return s.HistoryDays
+	}
+	return DefaultHistoryDays
+}
+
+// Fetch downloads the daily pages for the business days from start to end, but
+// no further back than HistoryDays. If start is zero, it downloads the
+// spreadsheets and the pages for all of HistoryDays.
+func (s Source) Fetch(ctx context.Context, client *http.Client, start, end time.Time) ([]byte, error) {
+	if s.URL != "" {
+		return internal.FetchContext(ctx, s.URL, internal.WithClient(client), DownloadOption)
+	}
+
+	first := end.AddDate(0, 0, 1-s.historyDays())
+	spreadsheets := start.IsZero()
+	if start.Before(first) {
+		start = first
+	}
+
+	var days []time.Time
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		if day.Weekday() != time.Saturday && day.Weekday() != time.Sunday {
+			days = append(days, day)
+		}
+	}
+	return s.fetch(ctx, client, spreadsheets, days)
+}
+
+// FetchMissing downloads the daily pages for the business days of the last
+// HistoryDays that have no cached rates. Public holidays never do, so their
+// pages are downloaded again on every refresh.
+//
+// The spreadsheets are downloaded too if the cache has no rates from before
+// HistoryDays, e.g. because it was written before they were configured.
+func (s Source) FetchMissing(ctx context.Context, client *http.Client, now time.Time, cached []time.Time) ([]byte, error) {
+	if s.URL != "" || len(cached) == 0 {
+		return s.Fetch(ctx, client, time.Time{}, now)
+	}
+	window := s.historyDays()
+	spreadsheets := !cached[0].Before(now.AddDate(0, 0, -window))
+	return s.fetch(ctx, client, spreadsheets, internal.MissingBusinessDays(now, window, cached))
+}
+
+// fetch downloads the spreadsheets, if requested, and the daily pages for the
+// days, and encodes their rates.
+//
+// A spreadsheet or a day whose page fails to download or parse (such as a
+// public holiday, which has no page) is skipped, so that it doesn't cost the
+// other days. Only if nothing succeeds is the first failure returned.
+func (s Source) fetch(ctx context.Context, client *http.Client, spreadsheets bool, days []time.Time) ([]byte, error) {
+	var rates []exchange.Rate
+	var firstErr error
+	if spreadsheets {
+		for _, uri := range s.Spreadsheets {
+			raw, err := internal.FetchContext(ctx, uri, internal.WithClient(client))
+			if err == nil {
+				var r []exchange.Rate
+				if r, err = parseSpreadsheet(raw); err == nil {
+					rates = append(rates, r...)
+					continue
+				}
+			}
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			if firstErr == nil {
+				firstErr = fmt.Errorf("spreadsheet %s: %w", uri, err)
+			}
+		}
+	}
+
+	// The pages are downloaded in parallel, but kept in order.
+	pages := make([][]exchange.Rate, len(days))
+	errs := make([]error, len(days))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallelDownloads && w < len(days); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				uri := SourceURLForDate(days[i])
+				raw, err := internal.FetchContext(ctx, uri, internal.WithClient(client), DownloadOption)
+				if err == nil {
+					if pages[i], err = parse(raw); err == nil {
+						continue
+					}
+				}
+				errs[i] = fmt.Errorf("%s: %w", uri, err)
+			}
+		}()
+	}
+	for i := range days {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for i := range days {
+		rates = append(rates, pages[i]...)
+		if firstErr == nil {
+			firstErr = errs[i]
+		}
+	}
+	if len(rates) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return internal.EncodeRates(rates), nil
+}
+
+// Parse reads either the CSV format returned by Fetch and Merge, or a single
+// daily page.
+func (Source) Parse(raw []byte) ([]exchange.Rate, error) {
+	if internal.IsEncodedRates(raw) {
+		return internal.DecodeRates(raw, "CBUAE")
+	}
+	return parse(raw)
+}
+
+// Merge adds the rates in fetched to the ones in cached. If both have a rate
+// for the same day and currency, the one in fetched wins.
+func (s Source) Merge(cached, fetched []byte) ([]byte, error) {
+	old, err := s.Parse(cached)
+	if err != nil {
+		return nil, fmt.Errorf("cached data: %w", err)
+	}
+	fresh, err := s.Parse(fetched)
+	if err != nil {
+		return nil, fmt.Errorf("fetched data: %w", err)
+	}
+	return internal.EncodeRates(append(old, fresh...)), nil
+}
+
 func parseDate(raw []byte) (time.Time, error) {
 	const needle = "Last updated:"
 	const endNeedle = "</p>"
@@ -152,6 +328,9 @@ func parse(raw []byte) ([]exchange.Rate, error) {
 		if err == nil {
 			if rate != nil {
 				rate.Rate = f
+				if f > 0 {
+					rate.Decimal = string(cell)
+				}
 				rates = append(rates, *rate)
 				rate = nil
 			}
@@ -1,8 +1,19 @@
 package cbuae
 
 import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/wowsignal-io/go-forex/forex/exchange"
 	"github.com/wowsignal-io/go-forex/forex/internal"
 )
 
@@ -32,3 +43,151 @@ func TestGet(t *testing.T) {
 		t.Errorf("Currency %s declared in currencies.txt, but not found in the output rates", currency)
 	}
 }
+
+func TestParseSpreadsheet(t *testing.T) {
+	raw, err := os.ReadFile("testdata/2024-05.xlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rates, err := parseSpreadsheet(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day := func(d int) time.Time { return time.Date(2024, time.May, d, 0, 0, 0, 0, time.UTC) }
+	want := []exchange.Rate{
+		{From: "USD", To: "AED", Rate: 3.6725, Decimal: "3.6725", Day: day(1), Info: "CBUAE"},
+		{From: "EUR", To: "AED", Rate: 3.9355, Decimal: "3.9355", Day: day(1), Info: "CBUAE"},
+		{From: "GBP", To: "AED", Rate: 4.6012, Decimal: "4.6012", Day: day(1), Info: "CBUAE"},
+		{From: "USD", To: "AED", Rate: 3.6725, Decimal: "3.6725", Day: day(2), Info: "CBUAE"},
+		{From: "EUR", To: "AED", Rate: 3.9312, Decimal: "3.9312", Day: day(2), Info: "CBUAE"},
+		{From: "JPY", To: "AED", Rate: 0.0239, Decimal: "0.0239", Day: day(3), Info: "CBUAE"},
+	}
+	if diff := cmp.Diff(want, rates); diff != "" {
+		t.Errorf("parseSpreadsheet -> (-) wanted vs. (+) got:\n%s", diff)
+	}
+
+	// Float noise in numeric cells doesn't pass for precision.
+	if got := spreadsheetDecimal("3.6724999999999999", 3.6725); got != "3.6725" {
+		t.Errorf("spreadsheetDecimal(3.6724999999999999) -> %q (wanted 3.6725)", got)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestFetchAndMerge(t *testing.T) {
+	page, err := os.ReadFile("testdata/2024-05-09.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The pages are downloaded in parallel.
+	var mu sync.Mutex
+	var requests []string
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, req.URL.Query().Get("dateTime"))
+		return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: io.NopCloser(bytes.NewReader(page))}, nil
+	})}
+	wantCurrencies, err := internal.Uniq("currencies.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := Source{HistoryDays: 8, Spreadsheets: []string{"testdata/2024-05.xlsx"}}
+	thursday := time.Date(2024, time.May, 9, 12, 0, 0, 0, time.UTC)
+	cached, err := src.Fetch(context.Background(), client, time.Time{}, thursday)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The last 8 days, without the weekend.
+	wantRequests := []string{"2024-05-02", "2024-05-03", "2024-05-06", "2024-05-07", "2024-05-08", "2024-05-09"}
+	sort.Strings(requests)
+	if diff := cmp.Diff(wantRequests, requests); diff != "" {
+		t.Errorf("Fetch requested (-) wanted vs. (+) got:\n%s", diff)
+	}
+
+	// All the pages say they're from Thursday, so they collapse into one day.
+	wantRates := 6 + len(wantCurrencies) - 1
+	rates, err := src.Parse(cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) != wantRates {
+		t.Errorf("Parse(Fetch()) -> %d rates, want %d", len(rates), wantRates)
+	}
+
+	requests = nil
+	fetched, err := src.Fetch(context.Background(), client, thursday.Truncate(24*time.Hour), thursday)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 {
+		t.Errorf("Fetch from the last cached day made %d requests, want 1", len(requests))
+	}
+	merged, err := src.Merge(cached, fetched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(merged, cached) {
+		t.Errorf("Merge with the same day changed the data:\n%s\nvs.\n%s", cached, merged)
+	}
+}
+
+func TestFetchMissing(t *testing.T) {
+	page, err := os.ReadFile("testdata/2024-05-09.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tuesday is a holiday, with no page.
+	var mu sync.Mutex
+	requested := map[string]bool{}
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		requested[req.URL.Query().Get("dateTime")] = true
+		mu.Unlock()
+		if req.URL.Query().Get("dateTime") == "2024-05-07" {
+			return &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found", Body: io.NopCloser(strings.NewReader("Not Found"))}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: io.NopCloser(bytes.NewReader(page))}, nil
+	})}
+
+	// Only recent days are cached, so the spreadsheets are fetched too.
+	src := Source{Spreadsheets: []string{"testdata/2024-05.xlsx"}}
+	thursday := time.Date(2024, time.May, 9, 12, 0, 0, 0, time.UTC)
+	cached := []time.Time{time.Date(2024, time.May, 6, 0, 0, 0, 0, time.UTC)}
+	fetched, err := src.FetchMissing(context.Background(), client, thursday, cached)
+	if err != nil {
+		t.Fatalf("FetchMissing with a holiday -> %v", err)
+	}
+	rates, err := src.Parse(fetched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	days := map[time.Time]bool{}
+	for _, r := range rates {
+		days[r.Day.UTC().Truncate(24*time.Hour)] = true
+	}
+	for _, d := range []int{1, 2, 3, 9} {
+		if day := time.Date(2024, time.May, d, 0, 0, 0, 0, time.UTC); !days[day] {
+			t.Errorf("FetchMissing has no rates on %v (got days %v)", day, days)
+		}
+	}
+
+	// By default, the daily pages go back a year.
+	if !requested["2023-05-11"] || requested["2023-05-08"] {
+		t.Errorf("FetchMissing requested 2023-05-08: %v, 2023-05-11: %v (wanted only the latter)", requested["2023-05-08"], requested["2023-05-11"])
+	}
+
+	// A spreadsheet that fails to download doesn't cost the daily pages.
+	src.Spreadsheets = []string{"testdata/does-not-exist.xlsx"}
+	if fetched, err = src.FetchMissing(context.Background(), client, thursday, cached); err != nil {
+		t.Errorf("FetchMissing with a missing spreadsheet -> %v", err)
+	} else if rates, err := src.Parse(fetched); err != nil || len(rates) == 0 {
+		t.Errorf("FetchMissing with a missing spreadsheet -> %d rates, %v", len(rates), err)
+	}
+}
@@ -0,0 +1,269 @@
+package cbuae
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wowsignal-io/go-forex/forex/exchange"
+)
+
+// The parts of the SpreadsheetML (xlsx) format we need: the shared strings
+// table and the cells of a worksheet.
+type xlsxSST struct {
+	Items []struct {
+		Text string `xml:"t"`
+		Runs []struct {
+			Text string `xml:"t"`
+		} `xml:"r"`
+	} `xml:"si"`
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string `xml:"r,attr"`
+			Type   string `xml:"t,attr"`
+			Value  string `xml:"v"`
+			Inline struct {
+				Text string `xml:"t"`
+			} `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func readZipXML(f *zip.File, v interface{}) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return xml.NewDecoder(r).Decode(v)
+}
+
+// columnIndex converts a cell reference like "AB12" to a zero-based column
+// index (27).
+func columnIndex(ref string) int {
+	col := 0
+	for _, c := range ref {
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		col = col*26 + int(c-'A'+1)
+	}
+	return col - 1
+}
+
+// readXLSX returns the cells of the first worksheet as text. Numbers (including
+// dates, which Excel stores as serial numbers) are returned as written in the
+// file.
+func readXLSX(raw []byte) ([][]string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		return nil, fmt.Errorf("not an xlsx file: %w", err)
+	}
+
+	var sst xlsxSST
+	var sheets []*zip.File
+	for _, f := range zr.File {
+		switch {
+		case f.Name == "xl/sharedStrings.xml":
+			if err := readZipXML(f, &sst); err != nil {
+				return nil, fmt.Errorf("reading shared strings: %w", err)
+			}
+		case path.Dir(f.Name) == "xl/worksheets" && path.Ext(f.Name) == ".xml":
+			sheets = append(sheets, f)
+		}
+	}
+	if len(sheets) == 0 {
+		return nil, fmt.Errorf("no worksheets found")
+	}
+	// Sheet names are sheet1.xml, sheet2.xml, ... in workbook order.
+	sort.Slice(sheets, func(i, j int) bool {
+		if len(sheets[i].Name) != len(sheets[j].Name) {
+			return len(sheets[i].Name) < len(sheets[j].Name)
+		}
+		return sheets[i].Name < sheets[j].Name
+	})
+
+	strs := make([]string, len(sst.Items))
+	for i, item := range sst.Items {
+		strs[i] = item.Text
+		for _, r := range item.Runs {
+			strs[i] += r.Text
+		}
+	}
+
+	var sheet xlsxSheet
+	if err := readZipXML(sheets[0], &sheet); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("reading %s: %w", sheets[0].Name, err)
+	}
+
+	rows := make([][]string, 0, len(sheet.Rows))
+	for _, r := range sheet.Rows {
+		var row []string
+		for i, c := range r.Cells {
+			col := i
+			if c.Ref != "" {
+				col = columnIndex(c.Ref)
+			}
+			if col < len(row) {
+				// Out of order cells are invalid; keep the first one.
+				continue
+			}
+			for len(row) < col {
+				row = append(row, "")
+			}
+
+			v := c.Value
+			switch c.Type {
+			case "s":
+				idx, err := strconv.Atoi(v)
+				if err != nil || idx < 0 || idx >= len(strs) {
+					return nil, fmt.Errorf("cell %s: invalid shared string %q", c.Ref, v)
+				}
+				v = strs[idx]
+			case "inlineStr":
+				v = c.Inline.Text
+			}
+			row = append(row, strings.TrimSpace(v))
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// Excel stores dates as the number of days since 30 December 1899. Serials in
+// this range are dates between 1982 and 2119, and too large to be rates.
+const (
+	minDateSerial = 30000
+	maxDateSerial = 80000
+)
+
+var spreadsheetDateFormats = []string{
+	"02/01/2006",
+	"2/1/2006",
+	"2006-01-02",
+	"02-01-2006",
+	"02-Jan-2006",
+	"2 January 2006",
+	"02 January 2006",
+}
+
+func parseSpreadsheetDate(cell string) (time.Time, bool) {
+	if f, err := strconv.ParseFloat(cell, 64); err == nil {
+		if f < minDateSerial || f >= maxDateSerial {
+			return time.Time{}, false
+		}
+		return time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC).AddDate(0, 0, int(f)), true
+	}
+	for _, format := range spreadsheetDateFormats {
+		if t, err := time.Parse(format, cell); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// spreadsheetDecimal returns the rate in the cell as a decimal string, or "" if
+// the cell isn't a plain decimal number. Numeric cells hold binary floats,
+// which can be written with noise in the last of 17 digits (e.g.
+// "3.6724999999999999"), so a cell with more than 15 significant digits is
+// replaced with the shortest decimal that parses to the same float.
+func spreadsheetDecimal(cell string, f float64) string {
+	digits := strings.Replace(cell, ".", "", 1)
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return ""
+	}
+	if len(strings.TrimLeft(digits, "0")) > 15 {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return cell
+}
+
+// parseSpreadsheet reads the rates from one of the monthly spreadsheets with
+// historical rates.
+//
+// Like the daily page, the spreadsheets have changed layout over time, so
+// cells are recognized by their content rather than by their position. Rows can
+// either name a currency, followed by its rate (with the date on the same row
+// or on a row above), or have a date followed by one rate for each of the
+// currencies named in a header row.
+func parseSpreadsheet(raw []byte) ([]exchange.Rate, error) {
+	rows, err := readXLSX(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	isos := map[string]string{}
+	for name, iso := range nameToISOMap() {
+		isos[strings.ToLower(name)] = iso
+		isos[strings.ToLower(iso)] = iso
+	}
+	currency := func(cell string) (string, bool) {
+		iso, ok := isos[strings.ToLower(cell)]
+		if !ok || iso == "AED" {
+			return "", false
+		}
+		return iso, true
+	}
+
+	rates := []exchange.Rate{}
+	var day time.Time
+	var header map[int]string
+	for _, row := range rows {
+		dateCol := -1
+		var currencyCols []int
+		for i, cell := range row {
+			if _, ok := currency(cell); ok {
+				currencyCols = append(currencyCols, i)
+				continue
+			}
+			if t, ok := parseSpreadsheetDate(cell); ok && dateCol < 0 {
+				day = t
+				dateCol = i
+			}
+		}
+
+		switch {
+		case len(currencyCols) > 1:
+			header = map[int]string{}
+			for _, i := range currencyCols {
+				header[i], _ = currency(row[i])
+			}
+		case len(currencyCols) == 1 && !day.IsZero():
+			i := currencyCols[0]
+			iso, _ := currency(row[i])
+			for j := i + 1; j < len(row); j++ {
+				if j == dateCol {
+					continue
+				}
+				if f, err := strconv.ParseFloat(row[j], 64); err == nil && f > 0 {
+					rates = append(rates, exchange.Rate{Info: "CBUAE", Day: day, To: "AED", From: iso, Rate: f, Decimal: spreadsheetDecimal(row[j], f)})
+					break
+				}
+			}
+		case dateCol >= 0 && header != nil:
+			for i, iso := range header {
+				if i >= len(row) || i == dateCol {
+					continue
+				}
+				if f, err := strconv.ParseFloat(row[i], 64); err == nil && f > 0 {
+					rates = append(rates, exchange.Rate{Info: "CBUAE", Day: day, To: "AED", From: iso, Rate: f, Decimal: spreadsheetDecimal(row[i], f)})
+				}
+			}
+		}
+	}
+
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("no rates found in spreadsheet")
+	}
+	return rates, nil
+}
@@ -1,10 +1,19 @@
+// Package cnb provides foreign exchange rates from the Czech National Bank.
+//
+// The CNB publishes rates from CZK to about 30 other currencies every business
+// day, both as a daily file and as a yearly file with the history of the year so
+// far. The Source loads the yearly files, going back to DefaultFirstYear. Once
+// they're cached, it only downloads the files of the current year (and of the
+// last cached day's year, if that was earlier).
 package cnb
 
 import (
 	"bytes"
+	"context"
 	"encoding/csv"
 	"fmt"
 	"io"
+	"net/http"
 	"strconv"
 	"strings"
 	"time"
@@ -13,6 +22,18 @@ import (
 	"github.com/wowsignal-io/go-forex/forex/internal"
 )
 
+// DefaultFirstYear is the first year of history a Source loads, unless
+// configured otherwise.
+const DefaultFirstYear = 2017
+
+// SourceURLForYear returns the URL of the yearly history file, which has the
+// rates published on every business day of the year.
+func SourceURLForYear(year int) string {
+	return fmt.Sprintf("https://www.cnb.cz/cs/financni-trhy/devizovy-trh/kurzy-devizoveho-trhu/kurzy-devizoveho-trhu/rok.txt?rok=%d", year)
+}
+
+// SourceURLForDate returns the URL of the daily file, which has the rates
+// published on a single day.
 func SourceURLForDate(date time.Time) string {
 	switch date.Weekday() {
 	case time.Saturday:
@@ -31,11 +52,163 @@ func Get(uri string) ([]exchange.Rate, error) {
 	return parse(raw)
 }
 
+// Source provides the CNB rates to forex.Exchange. The zero value loads the
+// yearly files since DefaultFirstYear.
+type Source struct {
+	// Where to download the rates from, instead of the yearly files. Can be a
+	// daily or a yearly file, a file path or a data URL.
+	URL string
+	// The first year of history to load, if not DefaultFirstYear.
+	FirstYear int
+}
+
+func (Source) Name() string { return "CNB" }
+
+func (Source) Attribution() string { return "Czech National Bank" }
+
+// Fetch downloads the yearly files covering the range, concatenated. A zero
+// start means the range begins in the first year.
+func (s Source) Fetch(ctx context.Context, client *http.Client, start, end time.Time) ([]byte, error) {
+	if s.URL != "" {
+		return internal.FetchContext(ctx, s.URL, internal.WithClient(client))
+	}
+
+	first := s.FirstYear
+	if first == 0 {
+		first = DefaultFirstYear
+	}
+	if start.Year() > first {
+		first = start.Year()
+	}
+
+	var buf bytes.Buffer
+	for year := first; year <= end.Year(); year++ {
+		raw, err := internal.FetchContext(ctx, SourceURLForYear(year), internal.WithClient(client))
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(raw)
+		if len(raw) > 0 && raw[len(raw)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Merge replaces the years in cached that fetched has again, and keeps the
+// older ones. If either isn't in the yearly format (e.g. with URL set), fetched
+// replaces cached.
+func (Source) Merge(cached, fetched []byte) ([]byte, error) {
+	old, oldOK := yearlyBlocks(cached)
+	fresh, freshOK := yearlyBlocks(fetched)
+	if !oldOK || !freshOK {
+		return fetched, nil
+	}
+	if len(fresh) == 0 {
+		return cached, nil
+	}
+	first := fresh[0].year
+	for _, b := range fresh {
+		if b.year < first {
+			first = b.year
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, b := range old {
+		if b.year < first {
+			buf.Write(b.data)
+		}
+	}
+	for _, b := range fresh {
+		buf.Write(b.data)
+	}
+	return buf.Bytes(), nil
+}
+
+// yearlyBlock is a header line of a yearly file, with the rows that follow it.
+type yearlyBlock struct {
+	year int
+	data []byte
+}
+
+// yearlyBlocks splits (possibly concatenated) yearly files into blocks. The
+// year of a block is the year of its first row, and blocks without rows (e.g.
+// the file of a new year before its first publication) are dropped. It returns
+// false if raw isn't in the yearly format.
+func yearlyBlocks(raw []byte) ([]yearlyBlock, bool) {
+	if !bytes.HasPrefix(raw, []byte("Datum|")) {
+		return nil, false
+	}
+	var blocks []yearlyBlock
+	for _, line := range strings.SplitAfter(string(raw), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+		if strings.HasPrefix(line, "Datum|") {
+			blocks = append(blocks, yearlyBlock{})
+		} else if b := &blocks[len(blocks)-1]; b.year == 0 {
+			date := line
+			if i := strings.IndexByte(line, '|'); i >= 0 {
+				date = line[:i]
+			}
+			t, err := time.Parse("02.01.2006", date)
+			if err != nil {
+				return nil, false
+			}
+			b.year = t.Year()
+		}
+		b := &blocks[len(blocks)-1]
+		b.data = append(b.data, line...)
+	}
+	withRows := blocks[:0]
+	for _, b := range blocks {
+		if b.year != 0 {
+			withRows = append(withRows, b)
+		}
+	}
+	return withRows, true
+}
+
+// Parse accepts both the daily and the (possibly concatenated) yearly files.
+func (Source) Parse(raw []byte) ([]exchange.Rate, error) {
+	if bytes.HasPrefix(raw, []byte("Datum|")) {
+		return parseYearly(raw)
+	}
+	return parse(raw)
+}
+
 func parseCzechDecimal(s string) (float64, error) {
 	s = strings.ReplaceAll(s, ",", ".")
 	return strconv.ParseFloat(s, 64)
 }
 
+// unitDecimal returns the rate of one unit as a decimal string, e.g. "0.15123"
+// for a rate of "15,123" per "100" units. It returns "" unless amount is a
+// power of ten.
+func unitDecimal(rate, amount string) string {
+	zeros := len(amount) - 1
+	if amount != "1"+strings.Repeat("0", zeros) {
+		return ""
+	}
+	rate = strings.ReplaceAll(rate, ",", ".")
+	whole, frac := rate, ""
+	if i := strings.IndexByte(rate, '.'); i >= 0 {
+		whole, frac = rate[:i], rate[i+1:]
+	}
+	if len(whole) <= zeros {
+		whole = strings.Repeat("0", zeros-len(whole)+1) + whole
+	}
+	whole, frac = whole[:len(whole)-zeros], whole[len(whole)-zeros:]+frac
+	if frac == "" {
+		return whole
+	}
+	return whole + "." + frac
+}
+
 func parse(raw []byte) ([]exchange.Rate, error) {
 	t, err := parseDate(raw)
 	if err != nil {
@@ -77,22 +250,106 @@ func parse(raw []byte) ([]exchange.Rate, error) {
 		}
 
 		rates = append(rates, exchange.Rate{
-			To:   "CZK",
-			From: record[3],
-			Day:  t,
-			Rate: rate / amount,
+			To:      "CZK",
+			From:    record[3],
+			Day:     t,
+			Rate:    rate / amount,
+			Info:    "CNB",
+			Decimal: unitDecimal(record[4], record[2]),
 		})
 	}
 
 	return rates, nil
 }
 
+// yearlyColumn is a currency in the header of the yearly file, e.g. "100 JPY".
+type yearlyColumn struct {
+	amount float64
+	unit   string
+	symbol string
+}
+
+func parseYearlyHeader(record []string) ([]yearlyColumn, error) {
+	columns := make([]yearlyColumn, len(record))
+	for i := 1; i < len(record); i++ {
+		fields := strings.Fields(record[i])
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid column header %q", record[i])
+		}
+		amount, err := parseCzechDecimal(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("parse amount: %w", err)
+		}
+		columns[i] = yearlyColumn{amount: amount, unit: fields[0], symbol: fields[1]}
+	}
+	return columns, nil
+}
+
+// parseYearly reads the yearly files. Each starts with a header line naming
+// the currencies, and the header repeats whenever the list of currencies
+// changes. Concatenated files parse just the same.
+func parseYearly(raw []byte) ([]exchange.Rate, error) {
+	rates := []exchange.Rate{}
+	cr := csv.NewReader(bytes.NewReader(raw))
+	cr.Comma = '|'
+	cr.LazyQuotes = true
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	var header []yearlyColumn
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if record[0] == "Datum" {
+			if header, err = parseYearlyHeader(record); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if len(record) != len(header) {
+			return nil, fmt.Errorf("row %q has %d columns, header has %d", record[0], len(record), len(header))
+		}
+
+		t, err := time.Parse("02.01.2006", record[0])
+		if err != nil {
+			return nil, err
+		}
+
+		for i := 1; i < len(record); i++ {
+			if record[i] == "" {
+				continue
+			}
+			rate, err := parseCzechDecimal(record[i])
+			if err != nil {
+				return nil, fmt.Errorf("parse rate: %w", err)
+			}
+			rates = append(rates, exchange.Rate{
+				To:      "CZK",
+				From:    header[i].symbol,
+				Day:     t,
+				Rate:    rate / header[i].amount,
+				Info:    "CNB",
+				Decimal: unitDecimal(record[i], header[i].unit),
+			})
+		}
+	}
+
+	return rates, nil
+}
+
+// parseDate reads the date on the first line of the daily file. Like the other
+// sources, the day is in UTC.
 func parseDate(raw []byte) (time.Time, error) {
-	format := "02.01.2006"
-	raw = raw[:len(format)]
-	loc, err := time.LoadLocation("Europe/Prague")
-	if err != nil {
-		panic(err)
+	const format = "02.01.2006"
+	if len(raw) < len(format) {
+		return time.Time{}, io.ErrUnexpectedEOF
 	}
-	return time.ParseInLocation("02.01.2006", string(raw), loc)
+	return time.Parse(format, string(raw[:len(format)]))
 }
@@ -1,7 +1,13 @@
 package cnb
 
 import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/wowsignal-io/go-forex/forex/internal"
 )
@@ -32,3 +38,129 @@ func TestGet(t *testing.T) {
 		t.Errorf("Currency %s declared in currencies.txt, but not found in the output rates", currency)
 	}
 }
+
+func TestParseYearly(t *testing.T) {
+	raw, err := os.ReadFile("testdata/rok.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rates, err := Source{}.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two days with 5 currencies, then a day with 4 after the header changes.
+	if len(rates) != 14 {
+		t.Errorf("Found %d rates (expected 14)", len(rates))
+	}
+
+	wantCurrencies, err := internal.Uniq("currencies.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	internal.ValidateAll(rates, wantCurrencies, func(i int, warnings []string) {
+		for _, warning := range warnings {
+			t.Errorf("Rate %d/%d invalid: %s", i+1, len(rates), warning)
+		}
+	})
+
+	// The JPY column is per 100 yen.
+	got := rates[len(rates)-2]
+	wantDay := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	if got.From != "JPY" || got.To != "CZK" || !got.Day.Equal(wantDay) || math.Abs(got.Rate-0.2013) > 1e-9 {
+		t.Errorf("rates[%d] = %+v, want JPY->CZK at 0.2013 on %v", len(rates)-2, got, wantDay)
+	}
+	if got.Decimal != "0.20130" {
+		t.Errorf("rates[%d].Decimal = %q, want 0.20130", len(rates)-2, got.Decimal)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	cached := "Datum|1 EUR|1 USD\n" +
+		"30.12.2021|24,860|21,950\n" +
+		"Datum|1 EUR|1 USD\n" +
+		"03.01.2022|24,800|21,900\n"
+	fetched := "Datum|1 EUR|1 USD\n" +
+		"03.01.2022|24,860|21,895\n" +
+		"04.01.2022|24,765|21,917"
+
+	got, err := Source{}.Merge([]byte(cached), []byte(fetched))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The cached 2022 file is replaced by the fetched one.
+	want := "Datum|1 EUR|1 USD\n" +
+		"30.12.2021|24,860|21,950\n" +
+		"Datum|1 EUR|1 USD\n" +
+		"03.01.2022|24,860|21,895\n" +
+		"04.01.2022|24,765|21,917\n"
+	if string(got) != want {
+		t.Errorf("Merge -> %q, want %q", got, want)
+	}
+
+	rates, err := Source{}.Parse(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) != 6 {
+		t.Errorf("Parse(Merge(...)) found %d rates (expected 6)", len(rates))
+	}
+}
+
+func TestMergeHeaderOnly(t *testing.T) {
+	cached := "Datum|1 EUR|1 USD\n" +
+		"29.12.2023|24,725|22,394\n" +
+		"Datum|1 EUR|1 USD\n" +
+		"02.01.2024|24,690|22,382\n"
+	for _, tc := range []struct {
+		fetched string
+		want    string
+	}{
+		{
+			// The file of the new year has no rows yet.
+			fetched: "Datum|1 EUR|1 USD\n" +
+				"02.01.2024|24,720|22,350\n" +
+				"Datum|1 EUR|1 USD\n",
+			want: "Datum|1 EUR|1 USD\n" +
+				"29.12.2023|24,725|22,394\n" +
+				"Datum|1 EUR|1 USD\n" +
+				"02.01.2024|24,720|22,350\n",
+		},
+		{
+			fetched: "Datum|1 EUR|1 USD\n",
+			want:    cached,
+		},
+	} {
+		got, err := Source{}.Merge([]byte(cached), []byte(tc.fetched))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("Merge(%q) -> %q, want %q", tc.fetched, got, tc.want)
+		}
+	}
+}
+
+func TestFetchFromStart(t *testing.T) {
+	var urls []string
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		urls = append(urls, req.URL.String())
+		rec := httptest.NewRecorder()
+		http.ServeFile(rec, req, "testdata/rok.txt")
+		return rec.Result(), nil
+	})}
+
+	start := time.Date(2022, time.March, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := (Source{}).Fetch(context.Background(), client, start, start.AddDate(0, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{SourceURLForYear(2022)}; len(urls) != 1 || urls[0] != want[0] {
+		t.Errorf("Fetch from %v downloaded %v, want %v", start, urls, want)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -10,10 +10,12 @@ package ecb
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"path/filepath"
 	"strconv"
 	"time"
@@ -29,7 +31,32 @@ func Get(uri string) ([]exchange.Rate, error) {
 	if err != nil {
 		return nil, err
 	}
+	return Source{}.Parse(raw)
+}
+
+// Source provides the ECB rates to forex.Exchange. The zero value downloads the
+// full history from DefaultECBSource.
+type Source struct {
+	// Where to download the zipped CSV from, if not DefaultECBSource. Can also
+	// be a file path or a data URL.
+	URL string
+}
+
+func (Source) Name() string { return "ECB" }
+
+func (Source) Attribution() string { return "European Central Bank" }
+
+// Fetch downloads the full history - the ECB doesn't publish smaller ranges in
+// the same format.
+func (s Source) Fetch(ctx context.Context, client *http.Client, start, end time.Time) ([]byte, error) {
+	uri := s.URL
+	if uri == "" {
+		uri = DefaultECBSource
+	}
+	return internal.FetchContext(ctx, uri, internal.WithClient(client))
+}
 
+func (Source) Parse(raw []byte) ([]exchange.Rate, error) {
 	rc, err := decompress(raw)
 	if err != nil {
 		return nil, err
@@ -99,11 +126,12 @@ func parse(r io.Reader) ([]exchange.Rate, error) {
 			}
 
 			result = append(result, exchange.Rate{
-				From: "EUR",
-				To:   currency,
-				Day:  t,
-				Rate: rate,
-				Info: "ECB",
+				From:    "EUR",
+				To:      currency,
+				Day:     t,
+				Rate:    rate,
+				Info:    "ECB",
+				Decimal: value,
 			})
 		}
 	}
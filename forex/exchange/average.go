@@ -0,0 +1,144 @@
+package exchange
+
+import (
+	"fmt"
+	"time"
+)
+
+// Period is a range of days, from Start to End inclusive, for AverageRate. Use
+// Month, Quarter or Year for calendar periods, or set Start and End for a
+// custom range.
+type Period struct {
+	Start, End time.Time
+}
+
+// Month returns the period of the given calendar month.
+func Month(year int, month time.Month) Period {
+	start := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	return Period{Start: start, End: start.AddDate(0, 1, -1)}
+}
+
+// Quarter returns the period of the given quarter (1 to 4) of the year.
+func Quarter(year, quarter int) Period {
+	start := time.Date(year, time.Month(3*(quarter-1)+1), 1, 0, 0, 0, 0, time.UTC)
+	return Period{Start: start, End: start.AddDate(0, 3, -1)}
+}
+
+// Year returns the period of the given calendar year.
+func Year(year int) Period {
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	return Period{Start: start, End: start.AddDate(1, 0, -1)}
+}
+
+func (p Period) String() string {
+	return fmt.Sprintf("%s to %s", p.Start.Format("2006-01-02"), p.End.Format("2006-01-02"))
+}
+
+// Averaging is an option for AverageRate. It specifies which days the average
+// is taken over.
+//
+// The default value is BusinessDays.
+type Averaging int8
+
+func (a Averaging) apply(opts *options) {
+	opts.averaging = a
+}
+
+func (a Averaging) String() string {
+	switch a {
+	case BusinessDays:
+		return "BusinessDays"
+	case CalendarDays:
+		return "CalendarDays"
+	default:
+		return "<invalid Averaging>"
+	}
+}
+
+const (
+	// The arithmetic mean of the rates on the days that have them, i.e.
+	// usually the business days.
+	BusinessDays Averaging = iota
+	// The arithmetic mean over all the days of the period. Days without a rate
+	// (weekends and holidays) carry the previous rate forward, including one
+	// from before the period. (See MaxCarryForward.)
+	CalendarDays
+)
+
+// MaxCarryForward is how far back CalendarDays averaging looks for a rate to
+// carry forward, unless Tolerance allows more.
+const MaxCarryForward = 7 * 24 * time.Hour
+
+// Average is the result of AverageRate.
+type Average struct {
+	// The average rate.
+	Rate float64
+	// The number of days in the period that have a rate of their own.
+	Observations int
+	// The number of days the average was taken over. With BusinessDays, this
+	// is the same as Observations. With CalendarDays, it includes the days
+	// that carried a rate forward, but not the days that had no rate to carry.
+	Days int
+	// The first and last days in the period with a rate of their own.
+	First, Last time.Time
+}
+
+// AverageRate computes the average exchange rate between the from and to
+// currencies over the period. The rate on each day is the one Convert would
+// return with the same options (but without Tolerance, which only extends the
+// carry-forward window of CalendarDays, and without Lookahead).
+//
+// Returns an error wrapping ErrNotFound if there is no rate in the period.
+func (g Graph) AverageRate(from, to string, period Period, opts ...Option) (Average, error) {
+	var o options
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+
+	start := period.Start.UTC().Truncate(24 * time.Hour)
+	end := period.End.UTC().Truncate(24 * time.Hour)
+	carry := MaxCarryForward
+	if o.tolerance > carry {
+		carry = o.tolerance
+	}
+	lookback := start
+	if o.averaging == CalendarDays {
+		lookback = start.Add(-carry)
+	}
+
+	// Only rates published on each day count as observations. The later
+	// options override the caller's.
+	dailyOpts := append(append([]Option{}, opts...), RateOnly, Tolerance(0), Lookahead(0))
+	daily := g.ConvertRange(from, to, lookback, end, dailyOpts...)
+
+	var avg Average
+	var sum, last float64
+	var lastDay time.Time
+	for _, r := range daily {
+		if r.Err == nil {
+			last, lastDay = r.Rate, r.Day
+		}
+		if r.Day.Before(start) {
+			continue
+		}
+
+		switch {
+		case r.Err == nil:
+			avg.Observations++
+			if avg.First.IsZero() {
+				avg.First = r.Day
+			}
+			avg.Last = r.Day
+		case o.averaging != CalendarDays || lastDay.IsZero() || r.Day.Sub(lastDay) > carry:
+			continue
+		}
+		sum += last
+		avg.Days++
+	}
+
+	if avg.Days == 0 {
+		return Average{}, fmt.Errorf("%w: %s to %s in %v", ErrNotFound, from, to, period)
+	}
+	avg.Rate = sum / float64(avg.Days)
+	return avg, nil
+}
@@ -0,0 +1,100 @@
+package exchange
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Query is one conversion for ConvertBatch: from the From currency to the To
+// currency on the Day.
+type Query struct {
+	From, To string
+	Day      time.Time
+}
+
+// BatchResult is the result of one Query passed to ConvertBatch.
+type BatchResult struct {
+	Result
+	// If the conversion failed, Err is the error Convert would return, and
+	// Result is empty.
+	Err error
+}
+
+// ConvertBatch runs many conversions at once. The result for each query is
+// the same as from Convert with the same options, and the results are in the
+// same order as the queries.
+//
+// With no options other than ResultType and Tolerance, ConvertBatch is much
+// faster than calling Convert for each query: queries from the same currency
+// on the same day share a single search, and the memory for the searches is
+// allocated only once. Other options fall back to calling Convert.
+func (g Graph) ConvertBatch(queries []Query, opts ...Option) []BatchResult {
+	res := make([]BatchResult, len(queries))
+
+	var o options
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+	if len(o.preferences) > 0 || len(o.constraints) > 0 || o.consensus != NoConsensus || o.arithmetic != FloatArithmetic || o.lookahead != 0 {
+		for i, q := range queries {
+			res[i].Result, res[i].Err = Convert(g, q.From, q.To, q.Day, opts...)
+		}
+		return res
+	}
+
+	// Order the queries by day, from the most recent, because the walker only
+	// goes back in time. Queries from the same currency on the same day end up
+	// next to each other.
+	days := make([]time.Time, len(queries))
+	order := make([]int, 0, len(queries))
+	for i, q := range queries {
+		days[i] = q.Day.UTC().Truncate(24 * time.Hour)
+		switch {
+		case q.From == q.To:
+			res[i].Rate = 1
+		case g[q.From] == nil:
+			res[i].Err = fmt.Errorf("%w: no data for currency %s", ErrNotFound, q.From)
+		default:
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		i, j := order[a], order[b]
+		if !days[i].Equal(days[j]) {
+			return days[i].After(days[j])
+		}
+		return queries[i].From < queries[j].From
+	})
+
+	w := newRangeWalker(g, &o)
+	for start := 0; start < len(order); {
+		first := order[start]
+		end := start + 1
+		for end < len(order) && days[order[end]].Equal(days[first]) && queries[order[end]].From == queries[first].From {
+			end++
+		}
+		group := order[start:end]
+		start = end
+
+		w.reset()
+		for _, i := range group {
+			if c := g[queries[i].To]; c != nil {
+				w.want(w.index[c])
+			}
+		}
+		if w.pending > 0 {
+			w.bfs(w.index[g[queries[first].From]], dayNumber(days[first]))
+		}
+
+		for _, i := range group {
+			q := queries[i]
+			if c := g[q.To]; c != nil && w.reached(w.index[c]) {
+				res[i].Result = w.result(w.index[c], o.resultType)
+			} else {
+				res[i].Err = fmt.Errorf("%w: %s to %s at %v (tolerance %v)", ErrNotFound, q.From, q.To, days[i], o.tolerance)
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,272 @@
+package exchange
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// The binary format of a Graph starts with graphMagic and the format version.
+// Then come:
+//
+//   - A table of all the strings (currency symbols, sources and decimals),
+//     each a uvarint length followed by the bytes. Index 0 is the empty
+//     string, which isn't stored.
+//   - The number of currencies, and the string index of each symbol, in
+//     alphabetical order.
+//   - For each currency, the number of its edges and the edges themselves, in
+//     the order they appear in the graph. An edge is the uvarint index of its
+//     target currency; the string index of its source shifted left by one,
+//     with the inverse flag in the low bit; its day (as a varint number of
+//     days since 1970 for the first edge, and as the uvarint number of days
+//     before the previous edge for the others); its rate as published, as 8
+//     bytes; and the string index of its decimal.
+//
+// All integers are little-endian.
+const (
+	graphMagic   = "FXG"
+	graphVersion = 2
+)
+
+var errTruncated = errors.New("truncated data")
+
+func appendUvarint(buf []byte, x uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutUvarint(tmp[:], x)]...)
+}
+
+func appendVarint(buf []byte, x int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutVarint(tmp[:], x)]...)
+}
+
+func appendUint64(buf []byte, x uint64) []byte {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], x)
+	return append(buf, tmp[:]...)
+}
+
+// MarshalBinary encodes the graph in a compact, versioned binary format, which
+// UnmarshalBinary decodes much faster than Compile builds the graph from
+// rates.
+func (g Graph) MarshalBinary() ([]byte, error) {
+	symbols := make([]string, 0, len(g))
+	for symbol := range g {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	index := make(map[*currency]uint64, len(g))
+	for i, symbol := range symbols {
+		index[g[symbol]] = uint64(i)
+	}
+
+	strs := []string{""}
+	strIndex := map[string]uint64{"": 0}
+	intern := func(s string) uint64 {
+		i, ok := strIndex[s]
+		if !ok {
+			i = uint64(len(strs))
+			strs = append(strs, s)
+			strIndex[s] = i
+		}
+		return i
+	}
+
+	var body []byte
+	body = appendUvarint(body, uint64(len(symbols)))
+	for _, symbol := range symbols {
+		body = appendUvarint(body, intern(symbol))
+	}
+	for _, symbol := range symbols {
+		c := g[symbol]
+		body = appendUvarint(body, uint64(len(c.dst)))
+		for i := range c.dst {
+			e := edge{c, i}
+			dst, ok := index[e.dst()]
+			if !ok {
+				return nil, fmt.Errorf("edge from %s to %s, which isn't in the graph", c.symbol, e.dst().symbol)
+			}
+			body = appendUvarint(body, dst)
+			body = appendUvarint(body, intern(e.source())<<1|uint64(c.meta[i]&1))
+
+			if i == 0 {
+				body = appendVarint(body, int64(c.day[i]))
+			} else {
+				body = appendUvarint(body, uint64(c.day[i-1]-c.day[i]))
+			}
+
+			body = appendUint64(body, math.Float64bits(c.rate[i]))
+			body = appendUvarint(body, intern(e.decimal()))
+		}
+	}
+
+	buf := append([]byte(graphMagic), graphVersion)
+	buf = appendUvarint(buf, uint64(len(strs)-1))
+	for _, s := range strs[1:] {
+		buf = appendUvarint(buf, uint64(len(s)))
+		buf = append(buf, s...)
+	}
+	return append(buf, body...), nil
+}
+
+// UnmarshalBinary decodes a graph encoded by MarshalBinary, replacing g. The
+// result is the same as the graph that was encoded.
+func (g *Graph) UnmarshalBinary(data []byte) error {
+	m, err := unmarshalGraph(data)
+	if err != nil {
+		return fmt.Errorf("decoding graph: %w", err)
+	}
+	*g = m
+	return nil
+}
+
+// graphReader decodes the parts of the binary format. After the first error,
+// it returns zero values.
+type graphReader struct {
+	data []byte
+	err  error
+}
+
+func (r *graphReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	x, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = errTruncated
+		return 0
+	}
+	r.data = r.data[n:]
+	return x
+}
+
+func (r *graphReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	x, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.err = errTruncated
+		return 0
+	}
+	r.data = r.data[n:]
+	return x
+}
+
+func (r *graphReader) uint64() uint64 {
+	b := r.bytes(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (r *graphReader) bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if uint64(len(r.data)) < n {
+		r.err = errTruncated
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+// index reads an index into a table of n elements.
+func (r *graphReader) index(n int) int {
+	i := r.uvarint()
+	if r.err == nil && i >= uint64(n) {
+		r.err = fmt.Errorf("index %d out of range [0, %d)", i, n)
+		return 0
+	}
+	return int(i)
+}
+
+func unmarshalGraph(data []byte) (Graph, error) {
+	if len(data) < len(graphMagic)+1 || string(data[:len(graphMagic)]) != graphMagic {
+		return nil, errors.New("not a graph")
+	}
+	if v := data[len(graphMagic)]; v != graphVersion {
+		return nil, fmt.Errorf("unsupported version %d (want %d)", v, graphVersion)
+	}
+	r := &graphReader{data: data[len(graphMagic)+1:]}
+
+	n := r.uvarint()
+	if n > uint64(len(r.data)) {
+		return nil, errTruncated
+	}
+	strs := make([]string, n+1)
+	for i := range strs[1:] {
+		strs[i+1] = string(r.bytes(r.uvarint()))
+	}
+
+	n = r.uvarint()
+	if n > uint64(len(r.data)) {
+		return nil, errTruncated
+	}
+	currencies := make([]*currency, n)
+	g := make(Graph, n)
+	for i := range currencies {
+		currencies[i] = &currency{symbol: strs[r.index(len(strs))]}
+		g[currencies[i].symbol] = currencies[i]
+	}
+	if r.err == nil && len(g) != len(currencies) {
+		return nil, errors.New("duplicate currency")
+	}
+
+	for _, c := range currencies {
+		n := r.uvarint()
+		// Each edge takes at least 12 bytes.
+		if n > uint64(len(r.data))/12 {
+			return nil, errTruncated
+		}
+		c.dst = make([]*currency, n)
+		c.day = make([]int32, n)
+		c.rate = make([]float64, n)
+		c.meta = make([]uint32, n)
+		c.decimal = make([]uint32, n)
+		c.strs = strs
+		var day int64
+		for i := range c.dst {
+			c.dst[i] = currencies[r.index(len(currencies))]
+
+			meta := r.uvarint()
+			if r.err == nil && meta>>1 >= uint64(len(strs)) {
+				r.err = fmt.Errorf("index %d out of range [0, %d)", meta>>1, len(strs))
+			}
+			c.meta[i] = uint32(meta)
+
+			if i == 0 {
+				day = r.varint()
+			} else {
+				day -= int64(r.uvarint())
+			}
+			if r.err == nil && (day < math.MinInt32 || day > math.MaxInt32) {
+				r.err = fmt.Errorf("day %d out of range", day)
+			}
+			c.day[i] = int32(day)
+
+			c.rate[i] = math.Float64frombits(r.uint64())
+			c.decimal[i] = uint32(r.index(len(strs)))
+			// Same as Compile, so ExactArithmetic can rely on it.
+			if r.err == nil && c.decimal[i] != 0 && !validDecimal(strs[c.decimal[i]]) {
+				r.err = fmt.Errorf("invalid decimal rate %q", strs[c.decimal[i]])
+			}
+			if r.err != nil {
+				return nil, r.err
+			}
+		}
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.data) != 0 {
+		return nil, fmt.Errorf("%d bytes of trailing data", len(r.data))
+	}
+	return g, nil
+}
@@ -0,0 +1,205 @@
+package exchange
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Consensus is an option for Convert. Instead of using a single path between
+// the two currencies, Convert evaluates all the shortest paths (or, together
+// with MaxHops, all the paths of up to that many rates) and returns their
+// median or mean rate. Result.Stats then shows how much the paths disagree.
+//
+// Between two currencies, each source's most recent rate makes a separate
+// path. The other options, such as constraints, still apply. At most
+// MaxConsensusPaths paths are evaluated.
+//
+// The default value is NoConsensus.
+type Consensus int8
+
+func (c Consensus) apply(opts *options) {
+	opts.consensus = c
+}
+
+func (c Consensus) String() string {
+	switch c {
+	case NoConsensus:
+		return "NoConsensus"
+	case ConsensusMedian:
+		return "ConsensusMedian"
+	case ConsensusMean:
+		return "ConsensusMean"
+	default:
+		return "<invalid Consensus>"
+	}
+}
+
+const (
+	// Use a single path.
+	NoConsensus Consensus = iota
+	// Use the median rate of all the paths.
+	ConsensusMedian
+	// Use the mean rate of all the paths.
+	ConsensusMean
+)
+
+// MaxConsensusPaths is the most paths evaluated by a Consensus query.
+const MaxConsensusPaths = 1000
+
+// PathStats describes the rates of all the paths evaluated by a Consensus
+// query.
+type PathStats struct {
+	// The number of paths.
+	Paths int
+	// Statistics of the paths' rates. StdDev is the population standard
+	// deviation.
+	Min, Max, Mean, Median, StdDev float64
+	// The trace of each path, in the format of Result.Trace.
+	Traces [][]Rate
+}
+
+// latestEdges returns the edges from c valid on day t, keeping only the nearest
+// rate from each source to each currency.
+func latestEdges(c *currency, t int32, o *options) []edge {
+	type key struct {
+		dst    *currency
+		source uint32
+	}
+	w := c.validEdges(t, o)
+	seen := make(map[key]bool, w.hi-w.lo)
+	res := make([]edge, 0, w.hi-w.lo)
+	for w.next() {
+		e := edge{c, w.i}
+		k := key{c.dst[w.i], c.meta[w.i] >> 1}
+		if !seen[k] {
+			seen[k] = true
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// distances returns the number of hops from each currency to the target,
+// ignoring constraints.
+func distances(to *currency, t int32, o *options) map[*currency]int {
+	// Every rate has an inverse, so the distance to the target is the distance
+	// from it.
+	dist := map[*currency]int{to: 0}
+	frontier := []*currency{to}
+	for n := 1; len(frontier) > 0; n++ {
+		var next []*currency
+		for _, c := range frontier {
+			w := c.validEdges(t, o)
+			for _, dst := range c.dst[w.lo:w.hi] {
+				if _, ok := dist[dst]; !ok {
+					dist[dst] = n
+					next = append(next, dst)
+				}
+			}
+		}
+		frontier = next
+	}
+	return dist
+}
+
+// consensus evaluates the paths from from to to, as described by Consensus.
+func consensus(from, to *currency, day time.Time, o *options) (Result, bool) {
+	if to == nil {
+		return Result{}, false
+	}
+	limit := 0
+	for _, c := range o.constraints {
+		if c.kind == maxHops && (limit == 0 || c.n < limit) {
+			limit = c.n
+		}
+	}
+	if limit == 0 {
+		// All the shortest paths.
+		s, ok := search(from, to, day, o)
+		if !ok {
+			return Result{}, false
+		}
+		for ; s.prev != nil; s = s.prev {
+			limit++
+		}
+	}
+
+	// A depth-first search over the simple paths of up to limit hops, pruning
+	// currencies too far from the target.
+	t := dayNumber(day)
+	dist := distances(to, t, o)
+	var paths [][]edge
+	var path []edge
+	onPath := map[*currency]bool{from: true}
+	var walk func(c *currency)
+	walk = func(c *currency) {
+		n := len(path) + 1
+		for _, e := range latestEdges(c, t, o) {
+			if len(paths) >= MaxConsensusPaths {
+				return
+			}
+			dst := e.dst()
+			if d, ok := dist[dst]; !ok || n+d > limit || onPath[dst] || !o.allows(e, n, to) {
+				continue
+			}
+			path = append(path, e)
+			if dst == to {
+				paths = append(paths, append([]edge(nil), path...))
+			} else {
+				onPath[dst] = true
+				walk(dst)
+				delete(onPath, dst)
+			}
+			path = path[:len(path)-1]
+		}
+	}
+	walk(from)
+	if len(paths) == 0 {
+		return Result{}, false
+	}
+
+	stats := &PathStats{Paths: len(paths), Traces: make([][]Rate, len(paths))}
+	rates := make([]float64, len(paths))
+	for i, p := range paths {
+		rates[i] = 1
+		stats.Traces[i] = make([]Rate, len(p))
+		for j, e := range p {
+			rates[i] *= e.rate()
+			stats.Traces[i][j] = e.toRate()
+		}
+	}
+
+	sorted := append([]float64(nil), rates...)
+	sort.Float64s(sorted)
+	stats.Min, stats.Max = sorted[0], sorted[len(sorted)-1]
+	if mid := len(sorted) / 2; len(sorted)%2 == 1 {
+		stats.Median = sorted[mid]
+	} else {
+		stats.Median = (sorted[mid-1] + sorted[mid]) / 2
+	}
+	for _, r := range rates {
+		stats.Mean += r
+	}
+	stats.Mean /= float64(len(rates))
+	for _, r := range rates {
+		stats.StdDev += (r - stats.Mean) * (r - stats.Mean)
+	}
+	stats.StdDev = math.Sqrt(stats.StdDev / float64(len(rates)))
+
+	res := Result{Rate: stats.Median, Stats: stats}
+	if o.consensus == ConsensusMean {
+		res.Rate = stats.Mean
+	}
+	if o.resultType == FullTrace {
+		// The trace of the path closest to the consensus.
+		closest := 0
+		for i, r := range rates {
+			if math.Abs(r-res.Rate) < math.Abs(rates[closest]-res.Rate) {
+				closest = i
+			}
+		}
+		res.Trace = stats.Traces[closest]
+	}
+	return res, true
+}
@@ -0,0 +1,184 @@
+package exchange
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CrossRates is the matrix of conversion rates between all the currencies on
+// one day, as returned by Graph.CrossRates.
+type CrossRates struct {
+	// The day in UTC.
+	Day time.Time
+	// The currencies in the graph, in alphabetical order.
+	Currencies []string
+
+	index     map[string]int
+	tolerance time.Duration
+	// The cell for converting Currencies[i] to Currencies[j] is at
+	// i*len(Currencies)+j. If found is false, the cell's error is in errs, or
+	// (if errs is nil) the usual ErrNotFound.
+	cells []Result
+	found []bool
+	errs  []error
+}
+
+// Convert returns the rate from the from currency to the to currency. The
+// result is the same as from Convert on the matrix's day, with the options
+// passed to CrossRates. It must not be modified.
+func (m *CrossRates) Convert(from, to string) (Result, error) {
+	if from == to {
+		return Result{Rate: 1}, nil
+	}
+	i, ok := m.index[from]
+	if !ok {
+		return Result{}, fmt.Errorf("%w: no data for currency %s", ErrNotFound, from)
+	}
+	j, ok := m.index[to]
+	if ok {
+		k := i*len(m.Currencies) + j
+		if m.found[k] {
+			return m.cells[k], nil
+		}
+		if m.errs != nil {
+			return Result{}, m.errs[k]
+		}
+	}
+	return Result{}, fmt.Errorf("%w: %s to %s at %v (tolerance %v)", ErrNotFound, from, to, m.Day, m.tolerance)
+}
+
+// CrossRates converts between all pairs of currencies on the day. Each result
+// is the same as from Convert with the same options, but CrossRates is much
+// faster than calling Convert for each pair: with no options other than
+// ResultType and Tolerance, it runs only one search from each currency. (Other
+// options fall back to calling Convert.)
+//
+// See CrossRatesCache to reuse the matrices of popular days.
+func (g Graph) CrossRates(day time.Time, opts ...Option) *CrossRates {
+	var o options
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+
+	m := &CrossRates{
+		Day:        day.UTC().Truncate(24 * time.Hour),
+		Currencies: make([]string, 0, len(g)),
+		index:      make(map[string]int, len(g)),
+		tolerance:  o.tolerance,
+	}
+	for symbol := range g {
+		m.Currencies = append(m.Currencies, symbol)
+	}
+	sort.Strings(m.Currencies)
+	for i, symbol := range m.Currencies {
+		m.index[symbol] = i
+	}
+	n := len(m.Currencies)
+	m.cells = make([]Result, n*n)
+	m.found = make([]bool, n*n)
+
+	if len(o.preferences) > 0 || len(o.constraints) > 0 || o.consensus != NoConsensus || o.arithmetic != FloatArithmetic || o.lookahead != 0 {
+		m.errs = make([]error, n*n)
+		for i, from := range m.Currencies {
+			for j, to := range m.Currencies {
+				k := i*n + j
+				m.cells[k], m.errs[k] = Convert(g, from, to, m.Day, opts...)
+				m.found[k] = m.errs[k] == nil
+			}
+		}
+		return m
+	}
+
+	w := newRangeWalker(g, &o)
+	for i, from := range m.Currencies {
+		w.reset()
+		for j, to := range m.Currencies {
+			if i != j {
+				w.want(w.index[g[to]])
+			}
+		}
+		w.bfs(w.index[g[from]], dayNumber(m.Day))
+
+		for j, to := range m.Currencies {
+			k := i*n + j
+			switch c := w.index[g[to]]; {
+			case i == j:
+				m.cells[k], m.found[k] = Result{Rate: 1}, true
+			case w.reached(c):
+				m.cells[k], m.found[k] = w.result(c, o.resultType), true
+			}
+		}
+	}
+	return m
+}
+
+// CrossRatesCache keeps the CrossRates of the most recently used days, so that
+// repeated conversions on popular days are simple lookups. It's safe for
+// concurrent use: a matrix is computed only once, and without blocking the
+// lookups of other days.
+type CrossRatesCache struct {
+	graph Graph
+	opts  []Option
+	size  int
+
+	mu sync.Mutex
+	// The entries, the most recently used at the front, and indexed by day.
+	lru  *list.List
+	days map[time.Time]*list.Element
+}
+
+// crossRatesEntry is the matrix of one day in CrossRatesCache, which the first
+// caller to need it computes.
+type crossRatesEntry struct {
+	day  time.Time
+	once sync.Once
+	m    *CrossRates
+}
+
+// NewCrossRatesCache returns a cache of the CrossRates of up to size days, with
+// the given options.
+func NewCrossRatesCache(g Graph, size int, opts ...Option) *CrossRatesCache {
+	return &CrossRatesCache{
+		graph: g,
+		opts:  opts,
+		size:  size,
+		lru:   list.New(),
+		days:  map[time.Time]*list.Element{},
+	}
+}
+
+// CrossRates returns the matrix for the day, computing it if it's not in the
+// cache.
+func (c *CrossRatesCache) CrossRates(day time.Time) *CrossRates {
+	day = day.UTC().Truncate(24 * time.Hour)
+
+	c.mu.Lock()
+	var entry *crossRatesEntry
+	if e, ok := c.days[day]; ok {
+		c.lru.MoveToFront(e)
+		entry = e.Value.(*crossRatesEntry)
+	} else {
+		entry = &crossRatesEntry{day: day}
+		c.days[day] = c.lru.PushFront(entry)
+		for c.lru.Len() > c.size && c.lru.Len() > 1 {
+			oldest := c.lru.Remove(c.lru.Back()).(*crossRatesEntry)
+			delete(c.days, oldest.day)
+		}
+	}
+	c.mu.Unlock()
+
+	// Callers waiting for an evicted entry still get its matrix.
+	entry.once.Do(func() {
+		entry.m = c.graph.CrossRates(day, c.opts...)
+	})
+	return entry.m
+}
+
+// Convert returns the rate from the from currency to the to currency on the
+// day. See CrossRates.Convert.
+func (c *CrossRatesCache) Convert(from, to string, day time.Time) (Result, error) {
+	return c.CrossRates(day).Convert(from, to)
+}
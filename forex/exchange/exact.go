@@ -0,0 +1,152 @@
+package exchange
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Arithmetic is an option for Convert. It specifies how the rates along the
+// path are multiplied.
+//
+// The default value is FloatArithmetic.
+type Arithmetic int8
+
+func (a Arithmetic) apply(opts *options) {
+	opts.arithmetic = a
+}
+
+func (a Arithmetic) String() string {
+	switch a {
+	case FloatArithmetic:
+		return "FloatArithmetic"
+	case ExactArithmetic:
+		return "ExactArithmetic"
+	default:
+		return "<invalid Arithmetic>"
+	}
+}
+
+const (
+	// Multiply float64 rates. Fast, but published rates like 1.1234 aren't
+	// exactly representable, and inverting and multiplying them adds more
+	// rounding error.
+	FloatArithmetic Arithmetic = iota
+	// Multiply the published decimal rates (see Rate.Decimal) as exact
+	// fractions, and populate Result.Exact and Result.Precision. Rates
+	// without a decimal representation are used at their exact float64 value.
+	//
+	// Doesn't apply to Consensus results.
+	ExactArithmetic
+)
+
+// parseDecimal parses a positive decimal number, as published by a source. It
+// returns the number of significant digits, which includes trailing zeros:
+// "1.1000" has 5.
+func parseDecimal(s string) (*big.Rat, int, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || strings.ContainsAny(s, "/xX") || r.Sign() <= 0 {
+		return nil, 0, fmt.Errorf("invalid decimal rate %q", s)
+	}
+	mantissa := strings.TrimPrefix(s, "+")
+	if i := strings.IndexAny(mantissa, "eE"); i >= 0 {
+		mantissa = mantissa[:i]
+	}
+	digits := strings.TrimLeft(strings.Replace(mantissa, ".", "", 1), "0")
+	return r, len(digits), nil
+}
+
+// validDecimal reports whether s is a positive decimal number, which
+// parseDecimal accepts, without the cost of parsing it.
+func validDecimal(s string) bool {
+	s = strings.TrimPrefix(s, "+")
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		exponent := s[i+1:]
+		if strings.HasPrefix(exponent, "+") || strings.HasPrefix(exponent, "-") {
+			exponent = exponent[1:]
+		}
+		if !isDigits(exponent) {
+			return false
+		}
+		s = s[:i]
+	}
+	s = strings.Replace(s, ".", "", 1)
+	return isDigits(s) && strings.Trim(s, "0") != ""
+}
+
+func isDigits(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
+
+// exact returns the rate of the edge as a fraction, and its significant
+// digits (0 if the rate has no decimal representation).
+func (e edge) exact() (*big.Rat, int, error) {
+	r, digits, err := parseDecimal(e.decimal())
+	if err != nil {
+		// Compile and UnmarshalBinary validated all decimals, so this must be
+		// a rate without one. Inverse rates are inverted as float64, same as
+		// without ExactArithmetic.
+		f := new(big.Rat).SetFloat64(e.rate())
+		if f == nil {
+			// The inverse of a zero rate is infinite.
+			rate := e.toRate()
+			return nil, 0, fmt.Errorf("%s to %s on %v: no exact value for rate %v", rate.From, rate.To, rate.Day, rate.Rate)
+		}
+		return f, 0, nil
+	}
+	if e.inverse() {
+		r.Inv(r)
+	}
+	return r, digits, nil
+}
+
+// exactProduct multiplies the exact rates of the edges. The precision is the
+// fewest significant digits of any of the rates, or 0 if any rate has no
+// decimal representation.
+func exactProduct(edges []edge) (*big.Rat, int, error) {
+	product := big.NewRat(1, 1)
+	precision := -1
+	for _, e := range edges {
+		r, digits, err := e.exact()
+		if err != nil {
+			return nil, 0, err
+		}
+		product.Mul(product, r)
+		if precision < 0 || digits < precision {
+			precision = digits
+		}
+	}
+	if precision < 0 {
+		precision = 0
+	}
+	return product, precision, nil
+}
+
+// DecimalString formats the exact rate to its stated precision, e.g.
+// "0.8902" for a rate computed from rates published with 4 and 5 significant
+// digits. Without ExactArithmetic, it formats Rate as precisely as needed to
+// parse back to the same float64.
+func (r Result) DecimalString() string {
+	if r.Exact == nil || r.Precision == 0 {
+		return big.NewFloat(r.Rate).Text('f', -1)
+	}
+
+	// The number of digits before the decimal point. Negative for numbers
+	// smaller than 0.1, which start with zeros after the decimal point.
+	abs := new(big.Rat).Abs(r.Exact)
+	intDigits := 0
+	if whole := new(big.Int).Quo(abs.Num(), abs.Denom()); whole.Sign() > 0 {
+		intDigits = len(whole.String())
+	} else if abs.Sign() > 0 {
+		ten := big.NewRat(10, 1)
+		for x := new(big.Rat).Set(abs); x.Cmp(big.NewRat(1, 10)) < 0; x.Mul(x, ten) {
+			intDigits--
+		}
+	}
+
+	decimals := r.Precision - intDigits
+	if decimals < 0 {
+		decimals = 0
+	}
+	return r.Exact.FloatString(decimals)
+}
@@ -13,6 +13,12 @@
 // the number of currencies and logarithmically with the number of days of
 // historical data.
 //
+// When there are several shortest paths, Convert uses the most recent rates,
+// and otherwise whichever rates come first. Pass a Preference, such as
+// PreferSource("ECB"), to choose the path by its rates. Constraints, such as
+// OnlySources("ECB") or MaxHops(2), restrict which paths Convert may use. With
+// a Consensus option, Convert combines the rates of all the shortest paths.
+//
 // The computed conversion rates are for informational purposes only - they are
 // unlikely to be the same as the rates actually offered, but the difference
 // should be tolerable for home finance applications.
@@ -21,6 +27,7 @@ package exchange
 import (
 	"errors"
 	"fmt"
+	"math/big"
 	"sort"
 	"time"
 )
@@ -41,89 +48,156 @@ type Rate struct {
 	// Additional information about how the rate was sourced. Usually the name
 	// of the central bank whose data was used.
 	Info string
+	// The rate exactly as published, as a decimal number (e.g. "1.1234"), if
+	// the source provides it. Used by ExactArithmetic, if it's a positive
+	// decimal number. For the inverse rates in Result.Trace, this is the
+	// published rate that was inverted.
+	Decimal string
 }
 
 // Graph is a compiled graph of currencies connected by their conversion rates.
 // Query using Convert, not directly.
 type Graph map[string]*currency
 
+// The rates are stored in columns: each currency has a slice for each field of
+// its rates, instead of a slice of structs. Days are numbered, and the source
+// names and decimals are interned in a table shared by the whole graph. Compile
+// adds the inverse of each published rate, but stores it as published: its
+// rate is inverted on the fly.
 type currency struct {
 	symbol string
-	// Must remain sorted from the most recent Rate.Day.
-	rates []edge
+	// The rates from this currency, sorted from the most recent day. The i-th
+	// rate is at index i of each column.
+	dst  []*currency
+	day  []int32
+	rate []float64
+	// The index of the source (Rate.Info) in strs, shifted left by one. The
+	// low bit is set for inverse rates.
+	meta []uint32
+	// The index of the decimal in strs.
+	decimal []uint32
+	// The strings of the whole graph.
+	strs []string
 }
 
+// edge refers to the i-th rate of the src currency.
 type edge struct {
-	src, dst *currency
-	rate     float64
-	day      time.Time
-	info     string
-	inverse  bool
+	src *currency
+	i   int
 }
 
-// Compile produces a graph used for currency conversion.
-func Compile(rates []Rate) (Graph, error) {
-	m := map[string]*currency{}
-	for _, rate := range rates {
-		day := rate.Day.Truncate(24 * time.Hour)
-		src, ok := m[rate.From]
-		if !ok {
-			src = &currency{symbol: rate.From}
-			m[rate.From] = src
-		}
+func (e edge) dst() *currency {
+	return e.src.dst[e.i]
+}
 
-		dst, ok := m[rate.To]
-		if !ok {
-			dst = &currency{symbol: rate.To}
-			m[rate.To] = dst
-		}
+func (e edge) day() int32 {
+	return e.src.day[e.i]
+}
 
-		src.rates = append(src.rates, edge{
-			src:  src,
-			dst:  dst,
-			rate: rate.Rate,
-			day:  day,
-			info: rate.Info})
-
-		dst.rates = append(dst.rates, edge{
-			src:     dst,
-			dst:     src,
-			rate:    1 / rate.Rate,
-			day:     day,
-			info:    rate.Info + " (inverse)",
-			inverse: true,
-		})
+func (e edge) inverse() bool {
+	return e.src.meta[e.i]&1 == 1
+}
+
+func (e edge) rate() float64 {
+	if e.inverse() {
+		return 1 / e.src.rate[e.i]
 	}
+	return e.src.rate[e.i]
+}
 
-	for _, c := range m {
-		sort.Slice(c.rates, func(i, j int) bool {
-			return c.rates[i].day.After(c.rates[j].day)
-		})
+func (e edge) info() string {
+	if e.inverse() {
+		return e.source() + inverseSuffix
 	}
+	return e.source()
+}
 
-	return m, nil
+func (e edge) decimal() string {
+	return e.src.strs[e.src.decimal[e.i]]
 }
 
-func filterEdges(edges []edge, day time.Time, tolerance time.Duration) []edge {
-	i := sort.Search(len(edges), func(i int) bool { return !edges[i].day.After(day) })
-	if i == len(edges) {
-		return nil
+const secondsPerDay = 24 * 60 * 60
+
+// dayNumber returns the number of days from 1970-01-01 to the day of t, in UTC.
+func dayNumber(t time.Time) int32 {
+	// Truncating aligns t to a multiple of days since the Unix epoch.
+	return int32(t.Truncate(24*time.Hour).Unix() / secondsPerDay)
+}
+
+// dayTime returns the start of the day number d, in UTC.
+func dayTime(d int32) time.Time {
+	return time.Unix(int64(d)*secondsPerDay, 0).UTC()
+}
+
+// days converts a number of days to a duration, for comparing with Tolerance.
+func days(n int32) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
+
+// Compile produces a graph used for currency conversion. An invalid Decimal is
+// dropped, and ExactArithmetic then uses the float64 Rate instead.
+func Compile(rates []Rate) (Graph, error) {
+	// The rates of each currency, before they're sorted and stored in columns.
+	type row struct {
+		dst           *currency
+		day           int32
+		rate          float64
+		meta, decimal uint32
 	}
+	rows := map[*currency][]row{}
 
-	edges = edges[i:]
-	day = day.Add(-24 * time.Hour).Add(-tolerance)
-	i = sort.Search(len(edges), func(i int) bool { return !edges[i].day.After(day) })
+	strs := []string{""}
+	index := map[string]uint32{"": 0}
+	intern := func(s string) uint32 {
+		i, ok := index[s]
+		if !ok {
+			i = uint32(len(strs))
+			strs = append(strs, s)
+			index[s] = i
+		}
+		return i
+	}
 
-	if i == len(edges) {
-		return edges
+	m := map[string]*currency{}
+	lookup := func(symbol string) *currency {
+		c, ok := m[symbol]
+		if !ok {
+			c = &currency{symbol: symbol}
+			m[symbol] = c
+		}
+		return c
 	}
 
-	// The call site expects to modify the contents of this slice, so we must
-	// return a copy.
-	res := make([]edge, i)
-	copy(res, edges[:i])
+	for _, rate := range rates {
+		if rate.Decimal != "" && !validDecimal(rate.Decimal) {
+			// One bad cell from a source shouldn't cost all the other rates.
+			rate.Decimal = ""
+		}
+		day := dayNumber(rate.Day)
+		src, dst := lookup(rate.From), lookup(rate.To)
+		source, decimal := intern(rate.Info), intern(rate.Decimal)
+		rows[src] = append(rows[src], row{dst: dst, day: day, rate: rate.Rate, meta: source << 1, decimal: decimal})
+		rows[dst] = append(rows[dst], row{dst: src, day: day, rate: rate.Rate, meta: source<<1 | 1, decimal: decimal})
+	}
 
-	return res
+	for c, rows := range rows {
+		sort.Slice(rows, func(i, j int) bool {
+			return rows[i].day > rows[j].day
+		})
+		c.dst = make([]*currency, len(rows))
+		c.day = make([]int32, len(rows))
+		c.rate = make([]float64, len(rows))
+		c.meta = make([]uint32, len(rows))
+		c.decimal = make([]uint32, len(rows))
+		for i, r := range rows {
+			c.dst[i], c.day[i], c.rate[i], c.meta[i], c.decimal[i] = r.dst, r.day, r.rate, r.meta, r.decimal
+		}
+	}
+	for _, c := range m {
+		c.strs = strs
+	}
+
+	return m, nil
 }
 
 // Result is a computed currency conversion rate obtained from Convert.
@@ -138,8 +212,22 @@ type Result struct {
 	// an intermediate currency (or two), then len(Trace) will be 2 or 3, while
 	// for direct rates the length will be 1.
 	//
-	// Only populated if Convert was called with the FullTrace option.
+	// Only populated if Convert was called with the FullTrace option. With a
+	// Consensus option, this is the path whose rate is closest to Rate. The Day
+	// of each rate is the day it was published, which Tolerance and Lookahead
+	// allow to differ from the day passed to Convert.
 	Trace []Rate
+	// Statistics about all the paths used to compute Rate. Only populated if
+	// Convert was called with a Consensus option.
+	Stats *PathStats
+	// The exact product of the rates along the path, and its precision: the
+	// number of significant digits of the least precise published rate (0 if
+	// a rate wasn't published as a decimal). Rate is the nearest float64 to
+	// Exact. See DecimalString.
+	//
+	// Only populated if Convert was called with ExactArithmetic.
+	Exact     *big.Rat
+	Precision int
 }
 
 // ResultType is an option for Convert. It specifies which fields of Result
@@ -172,6 +260,7 @@ const (
 
 // Tolerance is an option for Convert. When exchange data is not available on
 // the desired day, Tolerance specifies how many earlier days may be checked.
+// Rates are dated by day, so a fraction of a day is rounded down.
 //
 // The default value is 0 (exact match only).
 type Tolerance time.Duration
@@ -188,6 +277,46 @@ func AcceptOlderRate(maxAgeDays int) Tolerance {
 	return Tolerance(maxAgeDays) * 24 * Tolerance(time.Hour)
 }
 
+// Lookahead is an option for Convert. When exchange data is not available on
+// the desired day, Lookahead specifies how many later days may be checked, e.g.
+// to use the next business day's rate.
+//
+// If both Tolerance and Lookahead allow several days, Convert uses the rates
+// nearest to the desired day, and the earlier day if two are equally near.
+//
+// The default value is 0 (no later days).
+type Lookahead time.Duration
+
+func (l Lookahead) apply(opts *options) {
+	opts.lookahead = time.Duration(l)
+}
+
+func (l Lookahead) String() string {
+	return fmt.Sprintf("Lookahead(%d days)", l/Lookahead(time.Hour)/24)
+}
+
+func AcceptNewerRate(maxDays int) Lookahead {
+	return Lookahead(maxDays) * 24 * Lookahead(time.Hour)
+}
+
+// NearestRate returns an option for Convert, which uses the rates nearest to
+// the desired day, up to maxDays before or after it. It's the same as both
+// AcceptOlderRate(maxDays) and AcceptNewerRate(maxDays).
+func NearestRate(maxDays int) Option {
+	return nearestRate(maxDays)
+}
+
+type nearestRate int
+
+func (n nearestRate) apply(opts *options) {
+	AcceptOlderRate(int(n)).apply(opts)
+	AcceptNewerRate(int(n)).apply(opts)
+}
+
+func (n nearestRate) String() string {
+	return fmt.Sprintf("NearestRate(%d days)", int(n))
+}
+
 // Option for the Convert function. Specifies optional arguments, like whether
 // to accept stale exchange rates. See the list of types that implement this
 // interface for a list of options.
@@ -196,12 +325,19 @@ type Option interface {
 }
 
 type options struct {
-	resultType ResultType
-	tolerance  time.Duration
+	resultType  ResultType
+	tolerance   time.Duration
+	lookahead   time.Duration
+	preferences []Preference
+	constraints []Constraint
+	consensus   Consensus
+	averaging   Averaging
+	arithmetic  Arithmetic
 }
 
 // Convert from the from currency to the to currency using the provided exchange
-// graph. Only rates from the specified date will be used (but see Tolerance).
+// graph. Only rates from the specified date will be used (but see Tolerance and
+// Lookahead).
 //
 // Most users should use forex.Convert instead. The only reason to use this
 // function is if the application wants finer control over exchange data and
@@ -240,14 +376,36 @@ func Convert(exchange Graph, from, to string, t time.Time, opts ...Option) (Resu
 		return Result{}, fmt.Errorf("%w: no data for currency %s", ErrNotFound, from)
 	}
 
-	q := filterEdges(c.rates, t, o.tolerance)
+	if len(o.preferences) > 0 || len(o.constraints) > 0 || o.consensus != NoConsensus || o.arithmetic != FloatArithmetic {
+		if o.consensus != NoConsensus {
+			if res, ok := consensus(c, exchange[to], t, &o); ok {
+				return res, nil
+			}
+		} else if s, ok := search(c, exchange[to], t, &o); ok {
+			return s.result(&o)
+		}
+		if len(o.constraints) > 0 {
+			// Tell the caller whether it's the constraints' fault.
+			unconstrained := options{tolerance: o.tolerance, lookahead: o.lookahead}
+			if _, ok := search(c, exchange[to], t, &unconstrained); ok {
+				return Result{}, fmt.Errorf("%w: %s to %s at %v (tolerance %v): no path satisfies %v", ErrNotFound, from, to, t, o.tolerance, o.constraints)
+			}
+		}
+		return Result{}, fmt.Errorf("%w: %s to %s at %v (tolerance %v)", ErrNotFound, from, to, t, o.tolerance)
+	}
+
+	// The initial rates, from the from currency.
+	td := dayNumber(t)
+	var q []walkItem
+	for w := c.validEdges(td, &o); w.next(); {
+		e := edge{c, w.i}
+		q = append(q, walkItem{e: e, rate: e.rate()})
+	}
 	// What currencies have been visited in the QueueLoop
 	seen := make(map[string]bool, len(exchange))
-	// What edge was last seen per target currency by the RateLoop.
-	//
-	// TODO: this map seems to confuse heap escape analysis. (It adds about 10
-	// allocs per lookup.)
-	seenEdges := make(map[*currency]*edge, len(exchange))
+	// Which candidate (numbered from 1) last queued a rate to each currency,
+	// in the RateLoop.
+	lastFrom := make(map[*currency]int, len(exchange))
 	seen[from] = true
 	var trace map[*currency]edge
 	if o.resultType == FullTrace {
@@ -255,51 +413,48 @@ func Convert(exchange Graph, from, to string, t time.Time, opts ...Option) (Resu
 	}
 
 QueueLoop:
-	for len(q) > 0 {
+	for n := 1; len(q) > 0; n++ {
 		candidate := q[0]
 		q = q[1:]
+		dst := candidate.e.dst()
 
-		if seen[candidate.dst.symbol] {
+		if seen[dst.symbol] {
 			continue QueueLoop
 		}
 
-		if candidate.dst.symbol == to {
-			return finalize(candidate.rate, candidate, trace), nil
+		if dst.symbol == to {
+			return finalize(candidate.rate, candidate.e, trace), nil
 		}
 
-		// Binary search over the available rates (egdes). The rates are sorted
-		// by Day starting from the most recent. This finds the first possibly
-		// valid rate.
-		pred := func(i int) bool { return !candidate.dst.rates[i].day.After(t) }
-
+		// The valid rates (edges), from the nearest day. (Without Lookahead,
+		// that's the most recent.) When they run out, move on to the next
+		// candidate in the BFS queue.
 	RateLoop:
-		for i := sort.Search(len(candidate.dst.rates), pred); i < len(candidate.dst.rates); i++ {
-			e := candidate.dst.rates[i]
-			if t.Sub(e.day) > o.tolerance {
-				// No rates found on the day, or within tolerance. Move on to
-				// the next candidate in the BFS queue.
-				break RateLoop
-			}
-			// Only process the most recent edge - don't check multiple days of
+		for w := dst.validEdges(td, &o); w.next(); {
+			i := w.i
+			// Only process the nearest edge - don't check multiple days of
 			// edges leading to the same currency.
-			if seen[e.dst.symbol] || seenEdges[e.dst] == &candidate {
+			next := dst.dst[i]
+			if seen[next.symbol] || lastFrom[next] == n {
 				continue RateLoop
 			}
-			seenEdges[e.dst] = &candidate
+			lastFrom[next] = n
 
 			// The edge is valid on this day - push it onto the queue. If we're
 			// not keeping track of the full trace, then we also need to
 			// calculate the rate as we go. Here the partial product gets stored
-			// in the queue copy of each edge.
+			// in the queue.
+			e := edge{dst, i}
+			rate := e.rate()
 			if trace == nil {
-				e.rate *= candidate.rate
+				rate *= candidate.rate
 			}
-			q = append(q, e)
+			q = append(q, walkItem{e: e, rate: rate})
 		}
 
-		seen[candidate.dst.symbol] = true
+		seen[dst.symbol] = true
 		if trace != nil {
-			trace[candidate.dst] = candidate
+			trace[dst] = candidate.e
 		}
 	}
 
@@ -313,13 +468,13 @@ func finalize(rate float64, e edge, trace map[*currency]edge) Result {
 
 	path := []Rate{}
 	for {
-		path = append(path, Rate{From: e.src.symbol, To: e.dst.symbol, Rate: e.rate, Day: e.day, Info: e.info})
+		path = append(path, e.toRate())
 		prev, ok := trace[e.src]
 		if !ok {
 			break
 		}
 		e = prev
-		rate *= e.rate
+		rate *= e.rate()
 	}
 
 	// The trace is in the wrong order (going back to the start).
@@ -330,3 +485,7 @@ func finalize(rate float64, e edge, trace map[*currency]edge) Result {
 
 	return Result{Trace: path, Rate: rate}
 }
+
+func (e edge) toRate() Rate {
+	return Rate{From: e.src.symbol, To: e.dst().symbol, Rate: e.rate(), Day: dayTime(e.day()), Info: e.info(), Decimal: e.decimal()}
+}
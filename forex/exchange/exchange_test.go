@@ -1,7 +1,13 @@
 package exchange
 
 import (
+	"bytes"
 	"errors"
+	"math/big"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -18,6 +24,7 @@ func TestConvert(t *testing.T) {
 		from, to   string
 		day        time.Time
 		resultType ResultType
+		opts       []Option
 	}{
 		{
 			comment: "empty",
@@ -126,6 +133,91 @@ func TestConvert(t *testing.T) {
 				},
 			},
 		},
+		{
+			comment: "prefer source",
+			data: []Rate{
+				{From: "EUR", To: "USD", Day: time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC), Rate: 1.21, Info: "BOC"},
+				{From: "EUR", To: "USD", Day: time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC), Rate: 1.2, Info: "ECB"},
+				{From: "EUR", To: "USD", Day: time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC), Rate: 1.19, Info: "RBA"},
+			},
+			from: "USD",
+			to:   "EUR",
+			day:  time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC),
+			opts: []Option{PreferSource("ECB")},
+			want: Result{Rate: 1 / 1.2},
+		},
+		{
+			comment: "prefer freshest",
+			data: []Rate{
+				{From: "USD", To: "EUR", Day: time.Date(2022, time.January, 3, 0, 0, 0, 0, time.UTC), Rate: 0.9},
+				{From: "EUR", To: "CHF", Day: time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC), Rate: 1.1},
+				{From: "USD", To: "GBP", Day: time.Date(2022, time.January, 3, 0, 0, 0, 0, time.UTC), Rate: 0.8},
+				{From: "GBP", To: "CHF", Day: time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC), Rate: 1.3},
+			},
+			from:       "USD",
+			to:         "CHF",
+			day:        time.Date(2022, time.January, 3, 0, 0, 0, 0, time.UTC),
+			resultType: FullTrace,
+			opts:       []Option{AcceptOlderRate(3), PreferFreshest},
+			want: Result{
+				Rate: 0.8 * 1.3,
+				Trace: []Rate{
+					{From: "USD", To: "GBP", Rate: 0.8},
+					{From: "GBP", To: "CHF", Rate: 1.3},
+				},
+			},
+		},
+		{
+			comment: "prefer direct",
+			data: []Rate{
+				{From: "EUR", To: "USD", Day: time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC), Rate: 1.2},
+				{From: "USD", To: "EUR", Day: time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC), Rate: 0.8},
+			},
+			from: "USD",
+			to:   "EUR",
+			day:  time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC),
+			opts: []Option{PreferDirect},
+			want: Result{Rate: 0.8},
+		},
+		{
+			comment: "only sources",
+			data: []Rate{
+				{From: "EUR", To: "USD", Day: time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC), Rate: 1.2, Info: "ECB"},
+				{From: "EUR", To: "CHF", Day: time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC), Rate: 1.1, Info: "ECB"},
+				{From: "USD", To: "CHF", Day: time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC), Rate: 0.9, Info: "BOC"},
+			},
+			from: "USD",
+			to:   "CHF",
+			day:  time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC),
+			opts: []Option{OnlySources("ECB")},
+			want: Result{Rate: (1 / 1.2) * 1.1},
+		},
+		{
+			comment: "avoid currencies",
+			data: []Rate{
+				{From: "USD", To: "EUR", Day: time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC), Rate: 0.9},
+				{From: "EUR", To: "CHF", Day: time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC), Rate: 1.1},
+				{From: "USD", To: "GBP", Day: time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC), Rate: 0.8},
+				{From: "GBP", To: "CHF", Day: time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC), Rate: 1.3},
+			},
+			from: "USD",
+			to:   "CHF",
+			day:  time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC),
+			opts: []Option{AvoidCurrencies("EUR")},
+			want: Result{Rate: 0.8 * 1.3},
+		},
+		{
+			comment: "max hops",
+			data: []Rate{
+				{From: "USD", To: "EUR", Day: time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC), Rate: 0.9},
+				{From: "EUR", To: "CHF", Day: time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC), Rate: 1.1},
+			},
+			from:    "USD",
+			to:      "CHF",
+			day:     time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC),
+			opts:    []Option{MaxHops(1)},
+			wantErr: ErrNotFound,
+		},
 	} {
 		t.Run(tc.comment, func(t *testing.T) {
 			g, err := Compile(tc.data)
@@ -134,7 +226,7 @@ func TestConvert(t *testing.T) {
 			}
 			t.Logf("Compile(%#v)", tc.data)
 
-			result, err := Convert(g, tc.from, tc.to, tc.day, tc.resultType)
+			result, err := Convert(g, tc.from, tc.to, tc.day, append([]Option{tc.resultType}, tc.opts...)...)
 			if !errors.Is(err, tc.wantErr) {
 				t.Errorf("Convert(%#v, %q, %q, %v, %v) -> err=%v wanted (err=%v)", g, tc.from, tc.to, tc.day, tc.resultType, err, tc.wantErr)
 			}
@@ -145,3 +237,657 @@ func TestConvert(t *testing.T) {
 		})
 	}
 }
+
+func TestConvertConstraintError(t *testing.T) {
+	g, err := Compile([]Rate{
+		{From: "USD", To: "EUR", Day: time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC), Rate: 0.9, Info: "ECB"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Convert(g, "USD", "EUR", time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC), OnlySources("BOC"))
+	if !errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "no path satisfies [OnlySources(BOC)]") {
+		t.Errorf("Convert with OnlySources(BOC) -> %v, wanted ErrNotFound blaming the constraint", err)
+	}
+}
+
+func TestConvertFractionalTolerance(t *testing.T) {
+	g, err := Compile([]Rate{
+		{From: "USD", To: "EUR", Day: time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC), Rate: 0.9, Info: "ECB"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2022, time.January, 3, 0, 0, 0, 0, time.UTC)
+
+	// The rate is two days old. Preferences and constraints, which Convert
+	// handles with a different search, mustn't change whether it's found.
+	for _, tc := range []struct {
+		tolerance Tolerance
+		wantErr   error
+	}{
+		{Tolerance(36 * time.Hour), ErrNotFound},
+		{Tolerance(48 * time.Hour), nil},
+		{Tolerance(60 * time.Hour), nil},
+	} {
+		for _, opts := range [][]Option{{}, {PreferDirect}, {OnlySources("ECB")}, {ConsensusMedian}} {
+			opts = append(opts, tc.tolerance)
+			if _, err := Convert(g, "USD", "EUR", day, opts...); !errors.Is(err, tc.wantErr) {
+				t.Errorf("Convert(%v) -> err=%v, wanted %v", opts, err, tc.wantErr)
+			}
+		}
+	}
+}
+
+func TestConvertConsensus(t *testing.T) {
+	day := time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC)
+	g, err := Compile([]Rate{
+		{From: "EUR", To: "USD", Day: day, Rate: 1.2, Info: "ECB"},
+		{From: "EUR", To: "USD", Day: day, Rate: 1.25, Info: "BOC"},
+		{From: "USD", To: "CAD", Day: day, Rate: 1.3, Info: "BOC"},
+		{From: "EUR", To: "CAD", Day: day, Rate: 1.5, Info: "BOC"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		comment string
+		opts    []Option
+		want    Result
+	}{
+		{
+			comment: "shortest paths",
+			opts:    []Option{ConsensusMedian},
+			want: Result{
+				Rate: (1/1.2 + 1/1.25) / 2,
+				Stats: &PathStats{
+					Paths:  2,
+					Min:    1 / 1.25,
+					Max:    1 / 1.2,
+					Mean:   (1/1.2 + 1/1.25) / 2,
+					Median: (1/1.2 + 1/1.25) / 2,
+					StdDev: (1/1.2 - 1/1.25) / 2,
+				},
+			},
+		},
+		{
+			comment: "up to two hops",
+			opts:    []Option{ConsensusMedian, MaxHops(2)},
+			want: Result{
+				Rate: 1 / 1.2,
+				Stats: &PathStats{
+					Paths:  3,
+					Min:    1 / 1.25,
+					Max:    1.3 / 1.5,
+					Mean:   (1/1.2 + 1/1.25 + 1.3/1.5) / 3,
+					Median: 1 / 1.2,
+					StdDev: 0.0273,
+				},
+			},
+		},
+	} {
+		t.Run(tc.comment, func(t *testing.T) {
+			got, err := Convert(g, "USD", "EUR", day, tc.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Stats.Traces) != got.Stats.Paths {
+				t.Errorf("Convert(%v) -> %d traces for %d paths", tc.opts, len(got.Stats.Traces), got.Stats.Paths)
+			}
+			if diff := cmp.Diff(tc.want, got, cmpopts.EquateApprox(0, 0.0001), cmpopts.IgnoreFields(PathStats{}, "Traces")); diff != "" {
+				t.Errorf("Convert(%v) -> (-) wanted vs. (+) got:\n%s", tc.opts, diff)
+			}
+		})
+	}
+}
+
+func TestConvertExact(t *testing.T) {
+	day := time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC)
+	g, err := Compile([]Rate{
+		{From: "EUR", To: "USD", Day: day, Rate: 1.0842, Decimal: "1.0842", Info: "ECB"},
+		{From: "EUR", To: "CZK", Day: day, Rate: 25.123, Decimal: "25.123", Info: "ECB"},
+		{From: "EUR", To: "JPY", Day: day, Rate: 130.1, Info: "ECB"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		from, to  string
+		exact     string
+		precision int
+		decimal   string
+	}{
+		{"USD", "CZK", "25123/1084.2", 5, "23.172"},
+		{"CZK", "USD", "1.0842/25.123", 5, "0.043156"},
+		{"EUR", "USD", "1.0842", 5, "1.0842"},
+		// The JPY rate has no decimal, so the precision is unknown.
+		{"USD", "JPY", "130.1/1.0842", 0, ""},
+	} {
+		got, err := Convert(g, tc.from, tc.to, day, ExactArithmetic, FullTrace)
+		if err != nil {
+			t.Fatalf("Convert(%s, %s) -> %v", tc.from, tc.to, err)
+		}
+		want := exactRat(t, tc.exact)
+		if tc.precision == 0 {
+			// The float64 nearest to 130.1 isn't exactly 130.1.
+			want = new(big.Rat).Mul(new(big.Rat).SetFloat64(130.1), exactRat(t, "1/1.0842"))
+		}
+		if got.Exact == nil || got.Exact.Cmp(want) != 0 {
+			t.Errorf("Convert(%s, %s, ExactArithmetic) -> Exact = %v, wanted %v", tc.from, tc.to, got.Exact, want)
+		}
+		if f, _ := want.Float64(); got.Rate != f {
+			t.Errorf("Convert(%s, %s, ExactArithmetic) -> Rate = %v, wanted %v", tc.from, tc.to, got.Rate, f)
+		}
+		if got.Precision != tc.precision {
+			t.Errorf("Convert(%s, %s, ExactArithmetic) -> Precision = %d, wanted %d", tc.from, tc.to, got.Precision, tc.precision)
+		}
+		if tc.decimal != "" && got.DecimalString() != tc.decimal {
+			t.Errorf("Convert(%s, %s, ExactArithmetic).DecimalString() -> %s, wanted %s", tc.from, tc.to, got.DecimalString(), tc.decimal)
+		}
+		if got.Trace[0].Decimal == "" && tc.precision != 0 {
+			t.Errorf("Convert(%s, %s, ExactArithmetic) -> trace %v, wanted published decimals", tc.from, tc.to, got.Trace)
+		}
+	}
+
+	// Invalid decimals are dropped, but the rates are kept.
+	for _, decimal := range []string{"1,1", "0"} {
+		g, err := Compile([]Rate{{From: "EUR", To: "USD", Day: day, Rate: 1.1, Decimal: decimal}})
+		if err != nil {
+			t.Fatalf("Compile with Decimal %s -> %v", decimal, err)
+		}
+		got, err := Convert(g, "EUR", "USD", day, ExactArithmetic, FullTrace)
+		if err != nil || got.Precision != 0 || got.Exact.Cmp(new(big.Rat).SetFloat64(1.1)) != 0 || got.Trace[0].Decimal != "" {
+			t.Errorf("Convert(EUR, USD, ExactArithmetic) with Decimal %s -> %v, %v, wanted the float64 rate", decimal, got, err)
+		}
+	}
+
+	// The inverse of a zero rate has no exact value.
+	g, err = Compile([]Rate{{From: "EUR", To: "HUF", Day: day, Rate: 0, Info: "Broken"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Convert(g, "HUF", "EUR", day, ExactArithmetic); err == nil {
+		t.Errorf("Convert(HUF, EUR, ExactArithmetic) with a zero rate -> %v, wanted an error", got)
+	}
+}
+
+func TestConvertLookahead(t *testing.T) {
+	date := func(month time.Month, day int) time.Time {
+		year := 2022
+		if month == time.December {
+			year = 2021
+		}
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	g, err := Compile([]Rate{
+		{From: "USD", To: "EUR", Day: date(time.December, 30), Rate: 0.88},
+		{From: "USD", To: "EUR", Day: date(time.January, 3), Rate: 0.9},
+		{From: "EUR", To: "CHF", Day: date(time.December, 29), Rate: 1.04},
+		{From: "EUR", To: "CHF", Day: date(time.January, 4), Rate: 1.05},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		comment  string
+		to       string
+		day      time.Time
+		opts     []Option
+		wantRate float64
+		// The days of the rates used.
+		wantDays []time.Time
+		wantErr  error
+	}{
+		{
+			comment: "exact day only",
+			to:      "EUR",
+			day:     date(time.January, 1),
+			wantErr: ErrNotFound,
+		},
+		{
+			comment:  "next business day",
+			to:       "EUR",
+			day:      date(time.January, 1),
+			opts:     []Option{AcceptNewerRate(2)},
+			wantRate: 0.9,
+			wantDays: []time.Time{date(time.January, 3)},
+		},
+		{
+			comment: "next business day too late",
+			to:      "EUR",
+			day:     date(time.January, 1),
+			opts:    []Option{AcceptNewerRate(1)},
+			wantErr: ErrNotFound,
+		},
+		{
+			comment:  "nearest is newer",
+			to:       "EUR",
+			day:      date(time.January, 2),
+			opts:     []Option{NearestRate(3)},
+			wantRate: 0.9,
+			wantDays: []time.Time{date(time.January, 3)},
+		},
+		{
+			comment:  "nearest tie goes to older",
+			to:       "EUR",
+			day:      date(time.January, 1),
+			opts:     []Option{NearestRate(3)},
+			wantRate: 0.88,
+			wantDays: []time.Time{date(time.December, 30)},
+		},
+		{
+			comment:  "older and newer windows differ",
+			to:       "EUR",
+			day:      date(time.January, 1),
+			opts:     []Option{AcceptOlderRate(1), AcceptNewerRate(2)},
+			wantRate: 0.9,
+			wantDays: []time.Time{date(time.January, 3)},
+		},
+		{
+			comment:  "two hops",
+			to:       "CHF",
+			day:      date(time.January, 2),
+			opts:     []Option{NearestRate(3)},
+			wantRate: 0.9 * 1.05,
+			wantDays: []time.Time{date(time.January, 3), date(time.January, 4)},
+		},
+		{
+			comment:  "two hops with search",
+			to:       "CHF",
+			day:      date(time.January, 2),
+			opts:     []Option{NearestRate(3), PreferFreshest},
+			wantRate: 0.9 * 1.05,
+			wantDays: []time.Time{date(time.January, 3), date(time.January, 4)},
+		},
+		{
+			comment:  "two hops with consensus",
+			to:       "CHF",
+			day:      date(time.January, 2),
+			opts:     []Option{NearestRate(3), ConsensusMedian},
+			wantRate: 0.9 * 1.05,
+			wantDays: []time.Time{date(time.January, 3), date(time.January, 4)},
+		},
+	} {
+		t.Run(tc.comment, func(t *testing.T) {
+			got, err := Convert(g, "USD", tc.to, tc.day, append([]Option{FullTrace}, tc.opts...)...)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Convert(%v) -> err=%v, wanted %v", tc.opts, err, tc.wantErr)
+			}
+			var days []time.Time
+			for _, r := range got.Trace {
+				days = append(days, r.Day)
+			}
+			if diff := cmp.Diff(tc.wantRate, got.Rate, cmpopts.EquateApprox(0, 0.0001)); diff != "" {
+				t.Errorf("Convert(%v) -> (-) wanted vs. (+) got rate:\n%s", tc.opts, diff)
+			}
+			if diff := cmp.Diff(tc.wantDays, days); diff != "" {
+				t.Errorf("Convert(%v) -> (-) wanted vs. (+) got trace days:\n%s", tc.opts, diff)
+			}
+		})
+	}
+}
+
+// exactRat parses a fraction of decimals, like "1.5/3.25".
+func exactRat(t *testing.T, s string) *big.Rat {
+	num, den := s, "1"
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		num, den = s[:i], s[i+1:]
+	}
+	n, ok := new(big.Rat).SetString(num)
+	d, ok2 := new(big.Rat).SetString(den)
+	if !ok || !ok2 {
+		t.Fatalf("invalid fraction %q", s)
+	}
+	return n.Quo(n, d)
+}
+
+// walkerGraph compiles the rates that the tests of ConvertRange, ConvertBatch
+// and CrossRates compare with Convert, and any extra ones. USD to CHF goes via
+// EUR until January 4, when it's also published directly.
+func walkerGraph(t *testing.T, extra ...Rate) Graph {
+	t.Helper()
+	g, err := Compile(append([]Rate{
+		{From: "EUR", To: "USD", Day: time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC), Rate: 1.2, Info: "ECB"},
+		{From: "EUR", To: "CHF", Day: time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC), Rate: 1.1, Info: "ECB"},
+		{From: "EUR", To: "USD", Day: time.Date(2022, time.January, 4, 0, 0, 0, 0, time.UTC), Rate: 1.25, Info: "ECB"},
+		{From: "USD", To: "CHF", Day: time.Date(2022, time.January, 4, 0, 0, 0, 0, time.UTC), Rate: 0.9, Info: "BOC"},
+	}, extra...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// walkerOptions are the options the same tests compare with Convert.
+var walkerOptions = [][]Option{
+	{AcceptOlderRate(1)},
+	{AcceptOlderRate(1), FullTrace},
+	{AcceptOlderRate(1), PreferDirect},
+	// A fraction of a day is rounded down, like in Convert.
+	{Tolerance(36 * time.Hour), FullTrace},
+}
+
+func TestConvertRange(t *testing.T) {
+	g := walkerGraph(t)
+	start := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2022, time.January, 5, 0, 0, 0, 0, time.UTC)
+	for _, opts := range walkerOptions {
+		got := g.ConvertRange("USD", "CHF", start, end, opts...)
+		if len(got) != 5 {
+			t.Fatalf("ConvertRange(%v) -> %d results, wanted 5", opts, len(got))
+		}
+		for i, r := range got {
+			if day := start.AddDate(0, 0, i); !r.Day.Equal(day) {
+				t.Errorf("ConvertRange(%v)[%d].Day = %v, wanted %v", opts, i, r.Day, day)
+			}
+			want, wantErr := Convert(g, "USD", "CHF", r.Day, opts...)
+			if !errors.Is(r.Err, ErrNotFound) != (wantErr == nil) {
+				t.Errorf("ConvertRange(%v) on %v -> err=%v, wanted %v", opts, r.Day, r.Err, wantErr)
+			}
+			if diff := cmp.Diff(want, r.Result); diff != "" {
+				t.Errorf("ConvertRange(%v) on %v -> (-) wanted vs. (+) got:\n%s", opts, r.Day, diff)
+			}
+		}
+		if got[0].Err == nil || got[1].Err != nil || got[4].Err != nil {
+			t.Errorf("ConvertRange(%v) -> errors %v, %v, %v, wanted only on the first day", opts, got[0].Err, got[1].Err, got[4].Err)
+		}
+	}
+}
+
+func TestConvertBatch(t *testing.T) {
+	g := walkerGraph(t)
+	var queries []Query
+	for _, from := range []string{"USD", "EUR", "CHF", "XXX"} {
+		for _, to := range []string{"CHF", "USD", "EUR", "XXX"} {
+			for day := 5; day >= 1; day-- {
+				queries = append(queries, Query{From: from, To: to, Day: time.Date(2022, time.January, day, 12, 0, 0, 0, time.UTC)})
+			}
+		}
+	}
+	for _, opts := range walkerOptions {
+		got := g.ConvertBatch(queries, opts...)
+		if len(got) != len(queries) {
+			t.Fatalf("ConvertBatch(%v) -> %d results, wanted %d", opts, len(got), len(queries))
+		}
+		for i, q := range queries {
+			want, wantErr := Convert(g, q.From, q.To, q.Day, opts...)
+			if (got[i].Err == nil) != (wantErr == nil) || wantErr != nil && got[i].Err.Error() != wantErr.Error() {
+				t.Errorf("ConvertBatch(%v) for %+v -> err=%v, wanted %v", opts, q, got[i].Err, wantErr)
+			}
+			if diff := cmp.Diff(want, got[i].Result); diff != "" {
+				t.Errorf("ConvertBatch(%v) for %+v -> (-) wanted vs. (+) got:\n%s", opts, q, diff)
+			}
+		}
+	}
+}
+
+func TestCrossRates(t *testing.T) {
+	// A separate component of the graph.
+	g := walkerGraph(t, Rate{From: "AUD", To: "NZD", Day: time.Date(2022, time.January, 4, 0, 0, 0, 0, time.UTC), Rate: 1.1, Info: "RBA"})
+	symbols := []string{"AUD", "CHF", "EUR", "NZD", "USD", "XXX"}
+	for _, opts := range append(walkerOptions, []Option{AcceptOlderRate(1), OnlySources("ECB")}) {
+		for day := 1; day <= 5; day++ {
+			t0 := time.Date(2022, time.January, day, 0, 0, 0, 0, time.UTC)
+			m := g.CrossRates(t0, opts...)
+			if diff := cmp.Diff(symbols[:5], m.Currencies); diff != "" {
+				t.Errorf("CrossRates(%v).Currencies -> (-) wanted vs. (+) got:\n%s", opts, diff)
+			}
+			for _, from := range symbols {
+				for _, to := range symbols {
+					got, gotErr := m.Convert(from, to)
+					want, wantErr := Convert(g, from, to, t0, opts...)
+					if (gotErr == nil) != (wantErr == nil) || wantErr != nil && gotErr.Error() != wantErr.Error() {
+						t.Errorf("CrossRates(%v, %v).Convert(%s, %s) -> err=%v, wanted %v", t0, opts, from, to, gotErr, wantErr)
+					}
+					if diff := cmp.Diff(want, got); diff != "" {
+						t.Errorf("CrossRates(%v, %v).Convert(%s, %s) -> (-) wanted vs. (+) got:\n%s", t0, opts, from, to, diff)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestCrossRatesCache(t *testing.T) {
+	g, err := Compile([]Rate{
+		{From: "EUR", To: "USD", Day: time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC), Rate: 1.2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCrossRatesCache(g, 2, AcceptOlderRate(1))
+	day := func(d int) time.Time { return time.Date(2022, time.January, d, 15, 0, 0, 0, time.UTC) }
+	first := c.CrossRates(day(2))
+	if c.CrossRates(day(2)) != first {
+		t.Errorf("CrossRates(%v) computed the matrix twice", day(2))
+	}
+	got, err := c.Convert("USD", "EUR", day(3))
+	if err != nil || got.Rate != 1/1.2 {
+		t.Errorf("Convert(USD, EUR, %v) -> %v, %v, wanted %v", day(3), got, err, 1/1.2)
+	}
+	// Day 2 is the least recently used, so day 4 evicts it.
+	c.CrossRates(day(4))
+	if c.CrossRates(day(2)) == first {
+		t.Errorf("CrossRates(%v) wasn't evicted from a cache of 2 days", day(2))
+	}
+}
+
+func TestCrossRatesCacheConcurrent(t *testing.T) {
+	g, err := Compile([]Rate{
+		{From: "EUR", To: "USD", Day: time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC), Rate: 1.2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent callers for the same day share one matrix.
+	c := NewCrossRatesCache(g, 2)
+	day := time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC)
+	got := make([]*CrossRates, 8)
+	var wg sync.WaitGroup
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i] = c.CrossRates(day)
+		}(i)
+	}
+	wg.Wait()
+	for i, m := range got {
+		if m == nil || m != got[0] {
+			t.Errorf("CrossRates(%v) in goroutine %d -> %p, wanted %p", day, i, m, got[0])
+		}
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	var rates []Rate
+	for day := 1; day <= 20; day++ {
+		t := time.Date(2022, time.January, day, 0, 0, 0, 0, time.UTC)
+		rates = append(rates,
+			Rate{From: "EUR", To: "USD", Day: t, Rate: 1.1 + float64(day)/1000, Info: "ECB"},
+			Rate{From: "EUR", To: "CZK", Day: t, Rate: 25.1, Decimal: "25.100", Info: "ECB"},
+			Rate{From: "AUD", To: "USD", Day: t.AddDate(0, 0, day%3), Rate: 0.7, Decimal: "0.7", Info: "RBA"},
+		)
+	}
+	rates = append(rates, Rate{From: "USD", To: "CAD", Day: time.Date(1960, time.March, 1, 0, 0, 0, 0, time.UTC), Rate: 1.05, Info: "BOC"})
+	g, err := Compile(rates)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := g.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary -> %v", err)
+	}
+	var got Graph
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary -> %v", err)
+	}
+	for _, from := range []string{"AUD", "CAD", "CZK", "EUR", "USD"} {
+		for _, to := range []string{"AUD", "CAD", "CZK", "EUR", "USD"} {
+			for _, day := range []time.Time{rates[0].Day, rates[30].Day, rates[len(rates)-1].Day} {
+				for _, opts := range [][]Option{{FullTrace, AcceptOlderRate(2)}, {ExactArithmetic, FullTrace}} {
+					want, wantErr := Convert(g, from, to, day, opts...)
+					res, err := Convert(got, from, to, day, opts...)
+					if (err == nil) != (wantErr == nil) {
+						t.Errorf("Convert(%s, %s, %v) on the decoded graph -> err=%v, wanted %v", from, to, day, err, wantErr)
+					}
+					if diff := cmp.Diff(want, res, cmp.Comparer(func(a, b *big.Rat) bool { return a == b || a != nil && b != nil && a.Cmp(b) == 0 })); diff != "" {
+						t.Errorf("Convert(%s, %s, %v) on the decoded graph -> (-) wanted vs. (+) got:\n%s", from, to, day, diff)
+					}
+				}
+			}
+		}
+	}
+
+	invalidDecimal := bytes.Replace(data, []byte("25.100"), []byte("25,100"), 1)
+	for _, bad := range [][]byte{nil, data[:len(data)-1], append([]byte("FXG\x09"), data[4:]...), append(data, 0), invalidDecimal} {
+		if err := got.UnmarshalBinary(bad); err == nil {
+			t.Errorf("UnmarshalBinary(%d bytes of corrupted data) -> no error", len(bad))
+		}
+	}
+}
+
+func TestAverageRate(t *testing.T) {
+	g, err := Compile([]Rate{
+		{From: "EUR", To: "USD", Day: time.Date(2021, time.December, 31, 0, 0, 0, 0, time.UTC), Rate: 1},
+		{From: "EUR", To: "USD", Day: time.Date(2022, time.January, 3, 0, 0, 0, 0, time.UTC), Rate: 2},
+		{From: "EUR", To: "USD", Day: time.Date(2022, time.January, 4, 0, 0, 0, 0, time.UTC), Rate: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// From Saturday to Tuesday.
+	period := Period{Start: time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2022, time.January, 4, 0, 0, 0, 0, time.UTC)}
+	first := time.Date(2022, time.January, 3, 0, 0, 0, 0, time.UTC)
+	last := time.Date(2022, time.January, 4, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		comment string
+		period  Period
+		opts    []Option
+		want    Average
+		wantErr error
+	}{
+		{
+			comment: "business days",
+			period:  period,
+			want:    Average{Rate: 2.5, Observations: 2, Days: 2, First: first, Last: last},
+		},
+		{
+			comment: "calendar days",
+			period:  period,
+			opts:    []Option{CalendarDays},
+			// The weekend carries the rate from Friday before the period.
+			want: Average{Rate: (1 + 1 + 2 + 3) / 4.0, Observations: 2, Days: 4, First: first, Last: last},
+		},
+		{
+			comment: "year",
+			period:  Year(2022),
+			opts:    []Option{CalendarDays},
+			// The rate from the 4th carries forward for a week.
+			want: Average{Rate: (1 + 1 + 2 + 3*8) / 11.0, Observations: 2, Days: 11, First: first, Last: last},
+		},
+		{
+			comment: "no data",
+			period:  Quarter(2022, 2),
+			wantErr: ErrNotFound,
+		},
+	} {
+		t.Run(tc.comment, func(t *testing.T) {
+			got, err := g.AverageRate("EUR", "USD", tc.period, tc.opts...)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("AverageRate(%v, %v) -> err=%v wanted (err=%v)", tc.period, tc.opts, err, tc.wantErr)
+			}
+			if diff := cmp.Diff(tc.want, got, cmpopts.EquateApprox(0, 0.0001)); diff != "" {
+				t.Errorf("AverageRate(%v, %v) -> (-) wanted vs. (+) got:\n%s", tc.period, tc.opts, diff)
+			}
+		})
+	}
+}
+
+// benchmarkRates resembles the data of LiveExchange: 25 years of business days
+// of rates from three central banks, each against its own currency.
+func benchmarkRates() []Rate {
+	banks := []struct {
+		info, base string
+		quotes     []string
+	}{
+		{"ECB", "EUR", []string{"USD", "JPY", "BGN", "CZK", "DKK", "GBP", "HUF", "PLN", "RON", "SEK", "CHF", "ISK", "NOK", "TRY", "AUD", "BRL", "CAD", "CNY", "HKD", "IDR", "ILS", "INR", "KRW", "MXN", "MYR", "NZD", "PHP", "SGD", "THB", "ZAR"}},
+		{"BOC", "CAD", []string{"AUD", "BRL", "CNY", "EUR", "HKD", "INR", "IDR", "JPY", "MYR", "MXN", "NZD", "NOK", "PEN", "RUB", "SAR", "SGD", "ZAR", "KRW", "SEK", "CHF", "TWD", "TRY", "GBP", "USD"}},
+		{"RBA", "AUD", []string{"USD", "CNY", "JPY", "EUR", "KRW", "GBP", "SGD", "INR", "THB", "NZD", "TWD", "MYR", "IDR", "VND", "AED", "PGK", "HKD", "CAD", "CHF", "XDR"}},
+	}
+	var rates []Rate
+	start := time.Date(1999, time.January, 4, 0, 0, 0, 0, time.UTC)
+	for day := start; day.Year() < 2024; day = day.AddDate(0, 0, 1) {
+		if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+			continue
+		}
+		for _, bank := range banks {
+			for i, quote := range bank.quotes {
+				rate := 1 + float64(i) + float64(day.YearDay())/1000
+				rates = append(rates, Rate{From: bank.base, To: quote, Day: day, Rate: rate, Info: bank.info, Decimal: strconv.FormatFloat(rate, 'f', -1, 64)})
+			}
+		}
+	}
+	return rates
+}
+
+func BenchmarkCompile(b *testing.B) {
+	rates := benchmarkRates()
+	b.ReportAllocs()
+	b.ResetTimer()
+	var g Graph
+	for i := 0; i < b.N; i++ {
+		var err error
+		if g, err = Compile(rates); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	// The memory the graph keeps, per published rate.
+	var before, after runtime.MemStats
+	g = nil
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	g, _ = Compile(rates)
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	// Signed, as the heap may have shrunk if the GC freed other garbage.
+	kept := int64(after.HeapAlloc) - int64(before.HeapAlloc)
+	b.ReportMetric(float64(kept)/float64(len(rates)), "graph-B/rate")
+	runtime.KeepAlive(g)
+	runtime.KeepAlive(rates)
+}
+
+func BenchmarkConvert(b *testing.B) {
+	g, err := Compile(benchmarkRates())
+	if err != nil {
+		b.Fatal(err)
+	}
+	day := time.Date(2012, time.July, 19, 0, 0, 0, 0, time.UTC)
+	for _, bc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"RateOnly", []Option{RateOnly}},
+		{"FullTrace", []Option{FullTrace}},
+		{"Tolerance", []Option{RateOnly, AcceptOlderRate(5)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := Convert(g, "PGK", "PEN", day, bc.opts...); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
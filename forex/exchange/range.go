@@ -0,0 +1,243 @@
+package exchange
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// DatedResult is the conversion rate on one day of the range passed to
+// ConvertRange.
+type DatedResult struct {
+	// The day in UTC.
+	Day time.Time
+	Result
+	// If no rate was found on the day, Err wraps ErrNotFound and Result is
+	// empty.
+	Err error
+}
+
+// ConvertRange converts from the from currency to the to currency on every day
+// from start to end, inclusive. The result for each day is the same as from
+// Convert with the same options.
+//
+// With no options other than ResultType and Tolerance, ConvertRange is much
+// faster than calling Convert for each day: it walks the sorted rates of each
+// currency only once, and reuses its memory from day to day. Other options fall
+// back to calling Convert.
+func (g Graph) ConvertRange(from, to string, start, end time.Time, opts ...Option) []DatedResult {
+	start = start.UTC().Truncate(24 * time.Hour)
+	end = end.UTC().Truncate(24 * time.Hour)
+	if end.Before(start) {
+		return nil
+	}
+	days := int(end.Sub(start)/(24*time.Hour)) + 1
+	res := make([]DatedResult, days)
+	for i := range res {
+		res[i].Day = start.AddDate(0, 0, i)
+	}
+
+	var o options
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+	if from == to || len(o.preferences) > 0 || len(o.constraints) > 0 || o.consensus != NoConsensus || o.arithmetic != FloatArithmetic || o.lookahead != 0 || g[from] == nil {
+		for i := range res {
+			res[i].Result, res[i].Err = Convert(g, from, to, res[i].Day, opts...)
+		}
+		return res
+	}
+
+	// Going back in time, the valid rates of each currency only ever move
+	// further down its slice, which is sorted from the most recent.
+	w := newRangeWalker(g, &o)
+	src, dst := w.index[g[from]], -1
+	if c := g[to]; c != nil {
+		dst = w.index[c]
+	}
+	for i := days - 1; i >= 0; i-- {
+		t := res[i].Day
+		w.reset()
+		if dst >= 0 {
+			w.want(dst)
+			w.bfs(src, dayNumber(t))
+		}
+		if dst >= 0 && w.reached(dst) {
+			res[i].Result = w.result(dst, o.resultType)
+		} else {
+			res[i].Err = fmt.Errorf("%w: %s to %s at %v (tolerance %v)", ErrNotFound, from, to, t, o.tolerance)
+		}
+	}
+	return res
+}
+
+// rangeWalker runs the same BFS as Convert on successive days, going back in
+// time. Currencies are numbered, so that the state can live in slices, which
+// are reused from day to day. (ConvertBatch also uses it to answer several
+// queries from the same currency on the same day with one search.)
+type rangeWalker struct {
+	// The options, for the window of valid rates. (Lookahead isn't
+	// supported.)
+	opts       *options
+	currencies []*currency
+	index      map[*currency]int
+	// The valid rates of each currency are at the indices [lo, hi).
+	lo, hi []int
+	// The day the bounds were last moved to, to skip currencies not visited
+	// on some days.
+	boundsDay []int32
+
+	// BFS state. A currency is seen if seen[i] == gen, is a target if
+	// wanted[i] == gen, and was already queued by the current candidate if
+	// lastFrom[i] == tag. Seen currencies were reached with the rate in
+	// rates[i], the last edge being via[i].
+	gen, tag int
+	seen     []int
+	wanted   []int
+	pending  int
+	lastFrom []int
+	via      []edge
+	rates    []float64
+	queue    []walkItem
+}
+
+type walkItem struct {
+	e edge
+	// The partial product of the rates so far, in the order Convert
+	// multiplies them in RateOnly mode.
+	rate float64
+}
+
+func newRangeWalker(g Graph, o *options) *rangeWalker {
+	w := &rangeWalker{
+		opts:       o,
+		currencies: make([]*currency, 0, len(g)),
+		index:      make(map[*currency]int, len(g)),
+	}
+	for _, c := range g {
+		w.index[c] = len(w.currencies)
+		w.currencies = append(w.currencies, c)
+	}
+	n := len(w.currencies)
+	w.lo = make([]int, n)
+	w.hi = make([]int, n)
+	w.boundsDay = make([]int32, n)
+	for i := range w.boundsDay {
+		w.boundsDay[i] = math.MaxInt32
+	}
+	w.seen = make([]int, n)
+	w.wanted = make([]int, n)
+	w.lastFrom = make([]int, n)
+	w.via = make([]edge, n)
+	w.rates = make([]float64, n)
+	return w
+}
+
+// edges returns the indices [lo, hi) of the rates of currency i valid on day
+// t. Successive calls for the same currency must not move forward in time.
+func (w *rangeWalker) edges(i int, t int32) (lo, hi int) {
+	day := w.currencies[i].day
+	if w.boundsDay[i] != t {
+		w.boundsDay[i] = t
+		for w.lo[i] < len(day) && day[w.lo[i]] > t {
+			w.lo[i]++
+		}
+		if w.hi[i] < w.lo[i] {
+			w.hi[i] = w.lo[i]
+		}
+		for w.hi[i] < len(day) && w.opts.inWindow(day[w.hi[i]], t) {
+			w.hi[i]++
+		}
+	}
+	return w.lo[i], w.hi[i]
+}
+
+// reset clears the targets and the results of the last bfs.
+func (w *rangeWalker) reset() {
+	w.gen++
+	w.pending = 0
+}
+
+// want adds currency i to the targets of the next bfs.
+func (w *rangeWalker) want(i int) {
+	if w.wanted[i] != w.gen {
+		w.wanted[i] = w.gen
+		w.pending++
+	}
+}
+
+// reached reports whether the last bfs found a path to currency i.
+func (w *rangeWalker) reached(i int) bool {
+	return w.seen[i] == w.gen && w.via[i].src != nil
+}
+
+// bfs finds the paths from src to the targets on day t, recording them in via
+// and rates. It stops as soon as it has reached all the targets.
+func (w *rangeWalker) bfs(src int, t int32) {
+	w.queue = w.queue[:0]
+	w.seen[src] = w.gen
+	w.via[src] = edge{}
+
+	c := w.currencies[src]
+	lo, hi := w.edges(src, t)
+	for i := lo; i < hi; i++ {
+		e := edge{c, i}
+		w.queue = append(w.queue, walkItem{e: e, rate: e.rate()})
+	}
+
+	for head := 0; head < len(w.queue); head++ {
+		candidate := w.queue[head]
+		c := w.index[candidate.e.dst()]
+		if w.seen[c] == w.gen {
+			continue
+		}
+		w.seen[c] = w.gen
+		w.via[c] = candidate.e
+		w.rates[c] = candidate.rate
+		if w.wanted[c] == w.gen {
+			if w.pending--; w.pending == 0 {
+				return
+			}
+		}
+
+		// Like Convert, only take the most recent rate to each currency.
+		w.tag++
+		cur := w.currencies[c]
+		lo, hi := w.edges(c, t)
+		for i := lo; i < hi; i++ {
+			d := w.index[cur.dst[i]]
+			if w.seen[d] == w.gen || w.lastFrom[d] == w.tag {
+				continue
+			}
+			w.lastFrom[d] = w.tag
+			e := edge{cur, i}
+			w.queue = append(w.queue, walkItem{e: e, rate: e.rate() * candidate.rate})
+		}
+	}
+}
+
+// result returns the Result for the path to dst last found by bfs.
+func (w *rangeWalker) result(dst int, resultType ResultType) Result {
+	if resultType != FullTrace {
+		return Result{Rate: w.rates[dst]}
+	}
+
+	// Same as finalize.
+	e := w.via[dst]
+	rate := e.rate()
+	path := []Rate{e.toRate()}
+	for {
+		prev := w.via[w.index[e.src]]
+		if prev.src == nil {
+			break
+		}
+		e = prev
+		rate *= e.rate()
+		path = append(path, e.toRate())
+	}
+	for i := 0; i < len(path)/2; i++ {
+		j := len(path) - i - 1
+		path[i], path[j] = path[j], path[i]
+	}
+	return Result{Trace: path, Rate: rate}
+}
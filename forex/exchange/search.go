@@ -0,0 +1,388 @@
+package exchange
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Preference is an option for Convert. Convert always uses one of the shortest
+// paths between the two currencies, but there can be several, e.g. when two
+// central banks publish the same pair. Preferences choose between them.
+//
+// If several preferences are passed, the first one matters most, and the later
+// ones only break its ties. Remaining ties go to the most recent rates.
+type Preference struct {
+	kind    preferenceKind
+	sources []string
+}
+
+type preferenceKind int8
+
+const (
+	preferSource preferenceKind = iota
+	preferFreshest
+	preferDirect
+)
+
+var (
+	// PreferFreshest prefers the path whose farthest rate from the day is the
+	// nearest. (Without Lookahead, it's the path whose oldest rate is the most
+	// recent.) It only makes a difference together with AcceptOlderRate or
+	// AcceptNewerRate.
+	PreferFreshest = Preference{kind: preferFreshest}
+	// PreferDirect prefers rates in the direction the source published them,
+	// over computed inverse rates.
+	PreferDirect = Preference{kind: preferDirect}
+)
+
+// PreferSource prefers the paths with the most rates published by the named
+// sources (matching Rate.Info, such as "ECB"). Inverse rates count as coming
+// from the same source.
+func PreferSource(sources ...string) Preference {
+	return Preference{kind: preferSource, sources: sources}
+}
+
+func (p Preference) apply(opts *options) {
+	opts.preferences = append(opts.preferences, p)
+}
+
+func (p Preference) String() string {
+	switch p.kind {
+	case preferSource:
+		return fmt.Sprintf("PreferSource(%s)", strings.Join(p.sources, ", "))
+	case preferFreshest:
+		return "PreferFreshest"
+	case preferDirect:
+		return "PreferDirect"
+	default:
+		return "<invalid Preference>"
+	}
+}
+
+// cost returns the penalty for using the edge on day t. Lower is better.
+func (p *Preference) cost(e edge, t int32) int {
+	switch p.kind {
+	case preferSource:
+		if contains(p.sources, e.source()) {
+			return 0
+		}
+		return 1
+	case preferFreshest:
+		if d := int(t - e.day()); d > 0 {
+			return d
+		}
+		return int(e.day() - t)
+	case preferDirect:
+		if e.inverse() {
+			return 1
+		}
+		return 0
+	default:
+		return 0
+	}
+}
+
+// combine adds the cost of another edge to the cost of a path.
+func (p *Preference) combine(path, edge int) int {
+	if p.kind == preferFreshest {
+		// The path is as stale as its farthest rate.
+		if edge > path {
+			return edge
+		}
+		return path
+	}
+	return path + edge
+}
+
+// Constraint is an option for Convert, which restricts the rates and
+// currencies that Convert may use. If the constraints leave no path between
+// the two currencies, Convert returns ErrNotFound, even if there is a path
+// that breaks them.
+//
+// All constraints passed to Convert apply together.
+type Constraint struct {
+	kind  constraintKind
+	names []string
+	n     int
+}
+
+type constraintKind int8
+
+const (
+	onlySources constraintKind = iota
+	excludeSources
+	via
+	avoidCurrencies
+	maxHops
+)
+
+// OnlySources restricts Convert to the rates published by the named sources
+// (matching Rate.Info, such as "ECB"), and their inverses.
+func OnlySources(sources ...string) Constraint {
+	return Constraint{kind: onlySources, names: sources}
+}
+
+// ExcludeSources stops Convert from using the rates published by the named
+// sources, and their inverses.
+func ExcludeSources(sources ...string) Constraint {
+	return Constraint{kind: excludeSources, names: sources}
+}
+
+// Via restricts the intermediate currencies of the conversion to the listed
+// ones. Via() with no currencies allows only direct rates.
+func Via(currencies ...string) Constraint {
+	return Constraint{kind: via, names: currencies}
+}
+
+// AvoidCurrencies stops Convert from converting through any of the listed
+// currencies.
+func AvoidCurrencies(currencies ...string) Constraint {
+	return Constraint{kind: avoidCurrencies, names: currencies}
+}
+
+// MaxHops limits the conversion to at most n rates, i.e. n-1 intermediate
+// currencies.
+func MaxHops(n int) Constraint {
+	return Constraint{kind: maxHops, n: n}
+}
+
+func (c Constraint) apply(opts *options) {
+	opts.constraints = append(opts.constraints, c)
+}
+
+func (c Constraint) String() string {
+	switch c.kind {
+	case onlySources:
+		return fmt.Sprintf("OnlySources(%s)", strings.Join(c.names, ", "))
+	case excludeSources:
+		return fmt.Sprintf("ExcludeSources(%s)", strings.Join(c.names, ", "))
+	case via:
+		return fmt.Sprintf("Via(%s)", strings.Join(c.names, ", "))
+	case avoidCurrencies:
+		return fmt.Sprintf("AvoidCurrencies(%s)", strings.Join(c.names, ", "))
+	case maxHops:
+		return fmt.Sprintf("MaxHops(%d)", c.n)
+	default:
+		return "<invalid Constraint>"
+	}
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether the path may use the edge as its nth rate. The
+// destination only counts as an intermediate currency if it isn't the target.
+func (c *Constraint) allows(e edge, n int, to *currency) bool {
+	switch c.kind {
+	case onlySources:
+		return contains(c.names, e.source())
+	case excludeSources:
+		return !contains(c.names, e.source())
+	case via:
+		return e.dst() == to || contains(c.names, e.dst().symbol)
+	case avoidCurrencies:
+		return e.dst() == to || !contains(c.names, e.dst().symbol)
+	case maxHops:
+		return n <= c.n
+	default:
+		return true
+	}
+}
+
+// inverseSuffix marks the Info of the inverse rates added by Compile.
+const inverseSuffix = " (inverse)"
+
+// source returns the name of the source that published the edge's rate, even
+// for inverse edges.
+func (e edge) source() string {
+	return e.src.strs[e.src.meta[e.i]>>1]
+}
+
+// inWindow reports whether a rate published on day d is valid on day t: up to
+// Tolerance before t, or up to Lookahead after it. Every way of converting
+// checks rates with it, so that the options choosing between them (such as
+// preferences) never change whether a rate is found.
+func (o *options) inWindow(d, t int32) bool {
+	if d <= t {
+		return days(t-d) <= o.tolerance
+	}
+	return days(d-t) <= o.lookahead
+}
+
+// validEdges returns the rates of c valid on day t, with the options'
+// Tolerance and Lookahead.
+func (c *currency) validEdges(t int32, o *options) edgeWindow {
+	// The rates are sorted from the most recent, so the older ones come after
+	// the newer ones.
+	p := sort.Search(len(c.day), func(i int) bool { return c.day[i] <= t })
+	w := edgeWindow{day: c.day, t: t, older: p, hi: p, newer: p, lo: p}
+	for w.hi < len(c.day) && o.inWindow(c.day[w.hi], t) {
+		w.hi++
+	}
+	for w.lo > 0 && o.inWindow(c.day[w.lo-1], t) {
+		w.lo--
+	}
+	return w
+}
+
+// edgeWindow iterates over the indices of the rates valid on day t, from the
+// nearest day. Ties go to the earlier day, and rates on the same day keep their
+// order.
+type edgeWindow struct {
+	day []int32
+	t   int32
+	// The index of the current rate, after next returns true.
+	i int
+	// The remaining older rates are at [older, hi), and the remaining newer
+	// ones at [lo, newer), in the order of day. The rest of the newer day
+	// being visited is at [run, runEnd).
+	older, hi   int
+	lo, newer   int
+	run, runEnd int
+}
+
+// next moves to the next rate, returning false if there are none left.
+func (w *edgeWindow) next() bool {
+	switch {
+	case w.run < w.runEnd:
+		w.i = w.run
+		w.run++
+	case w.older < w.hi && (w.newer == w.lo || w.t-w.day[w.older] <= w.day[w.newer-1]-w.t):
+		w.i = w.older
+		w.older++
+	case w.newer > w.lo:
+		d := w.day[w.newer-1]
+		w.runEnd = w.newer
+		for w.newer > w.lo && w.day[w.newer-1] == d {
+			w.newer--
+		}
+		w.i = w.newer
+		w.run = w.newer + 1
+	default:
+		return false
+	}
+	return true
+}
+
+// step is how a currency was reached in search: via an edge from the
+// previous step, at the cost of the path so far. The first step has no edge.
+type step struct {
+	e    edge
+	prev *step
+	cost []int
+}
+
+// less compares the costs of two paths.
+func less(a, b []int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+func (o *options) allows(e edge, n int, to *currency) bool {
+	for i := range o.constraints {
+		if !o.constraints[i].allows(e, n, to) {
+			return false
+		}
+	}
+	return true
+}
+
+// search is the slower, more thorough alternative to the BFS in Convert. It
+// also visits the currencies layer by layer, but compares all the paths of
+// each layer, keeping the cheapest one to each currency.
+//
+// Edges that break the constraints are skipped.
+//
+// Without preferences, every path costs the same, and the first path found to
+// each currency is kept, so that search finds the same path as Convert.
+func search(from, to *currency, day time.Time, o *options) (*step, bool) {
+	t := dayNumber(day)
+	best := map[*currency]*step{from: {cost: make([]int, len(o.preferences))}}
+	// The number of hops to reach each currency.
+	hops := map[*currency]int{from: 0}
+	frontier := []*currency{from}
+	for n := 1; len(frontier) > 0; n++ {
+		var next []*currency
+		for _, c := range frontier {
+			cur := best[c]
+			for w := c.validEdges(t, o); w.next(); {
+				e := edge{c, w.i}
+				dst := e.dst()
+				if h, ok := hops[dst]; ok && h < n {
+					continue
+				}
+				if !o.allows(e, n, to) {
+					continue
+				}
+
+				cost := make([]int, len(o.preferences))
+				for j := range o.preferences {
+					p := &o.preferences[j]
+					cost[j] = p.combine(cur.cost[j], p.cost(e, t))
+				}
+				if prev, ok := best[dst]; ok && !less(cost, prev.cost) {
+					continue
+				}
+
+				if _, ok := hops[dst]; !ok {
+					hops[dst] = n
+					next = append(next, dst)
+				}
+				best[dst] = &step{e: e, prev: cur, cost: cost}
+			}
+		}
+
+		if s, ok := best[to]; ok {
+			return s, true
+		}
+		frontier = next
+	}
+	return nil, false
+}
+
+// result builds the Result for the path ending with s. It fails only if
+// ExactArithmetic can't represent one of the rates.
+func (s *step) result(o *options) (Result, error) {
+	var edges []edge
+	for ; s.prev != nil; s = s.prev {
+		edges = append(edges, s.e)
+	}
+	// The path is in the wrong order (going back to the start).
+	for i := 0; i < len(edges)/2; i++ {
+		j := len(edges) - i - 1
+		edges[i], edges[j] = edges[j], edges[i]
+	}
+
+	var res Result
+	if o.arithmetic == ExactArithmetic {
+		var err error
+		if res.Exact, res.Precision, err = exactProduct(edges); err != nil {
+			return Result{}, err
+		}
+		res.Rate, _ = res.Exact.Float64()
+	} else {
+		res.Rate = 1
+		for _, e := range edges {
+			res.Rate *= e.rate()
+		}
+	}
+	if o.resultType == FullTrace {
+		res.Trace = make([]Rate, len(edges))
+		for i, e := range edges {
+			res.Trace[i] = e.toRate()
+		}
+	}
+	return res, nil
+}
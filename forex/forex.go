@@ -3,7 +3,7 @@
 //
 // Historical exchange rates for about 50 currencies are sourced from central
 // banks and cached locally after the first request. Custom sources can be
-// ingested via Exchange.AddSource().
+// ingested via Exchange.Add().
 //
 // Two preconfigured exchanges are provided: LiveExchange() refreshes data from
 // online sources, while OfflineExchange() only uses a smaller historical
@@ -13,7 +13,9 @@
 // Central banks don't provide all exchange rates directly, and some must be
 // computed using a third (and sometimes a fourth) currency as an intermediate
 // step. The algorithm in this package always discovers the shortest path
-// available - it doesn't attempt to find the best exchange rate.
+// available - it doesn't attempt to find the best exchange rate. When several
+// sources offer paths of the same length, options such as
+// exchange.PreferSource choose between them.
 //
 // The runtime cost of queries grows logarithmically with the length of
 // historical data and linearly with the number of currencies. A query on the
@@ -25,19 +27,21 @@
 package forex
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
-	"regexp"
+	"sort"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/wowsignal-io/go-forex/forex/boc"
 	"github.com/wowsignal-io/go-forex/forex/cbuae"
+	"github.com/wowsignal-io/go-forex/forex/cnb"
 	"github.com/wowsignal-io/go-forex/forex/ecb"
 	"github.com/wowsignal-io/go-forex/forex/exchange"
 	"github.com/wowsignal-io/go-forex/forex/internal"
@@ -54,18 +58,40 @@ var (
 // about twice per day.
 //
 // Currently, this exchange is built from historical rates supplied by the
-// European Central Bank, the Royal Bank of Australia and the Bank of Canada. It
+// European Central Bank, the Royal Bank of Australia, the Bank of Canada and the
+// Czech National Bank. Rates from the Central Bank of the U.A.E. accumulate in
+// the cache from the first use, starting with the last week. The exchange
 // contains about 50 currencies.
 func LiveExchange() *Exchange {
 	defaultOnce.Do(func() {
 		defaultExchange = &Exchange{
 			CacheLife: DefaultCacheLife,
 			CacheDir:  DefaultCacheDir(),
+			Snapshot:  true,
 		}
-		defaultExchange.AddSource("ECB", ecb.DefaultECBSource, ecb.Get)
-		defaultExchange.AddSource("RBA", rba.DefaultRBASource, rba.Get)
-		defaultExchange.AddSource("BOC", boc.DefaultBOCSource, boc.Get)
-		defaultExchange.AddSource("CBUAE", cbuae.SourceURLForDate(time.Now()), cbuae.Get, cbuae.DownloadOption)
+		// The schedules leave a margin after the usual publication times, in
+		// case the banks run late.
+		defaultExchange.Add(ecb.Source{}, WithSchedule(Schedule{
+			At:           16*time.Hour + 30*time.Minute,
+			Location:     loadLocation("Europe/Berlin", 1*60*60),
+			BusinessDays: true,
+		}))
+		defaultExchange.Add(rba.Source{}, WithSchedule(Schedule{
+			At:           16*time.Hour + 30*time.Minute,
+			Location:     loadLocation("Australia/Sydney", 10*60*60),
+			BusinessDays: true,
+		}))
+		defaultExchange.Add(boc.Source{}, WithSchedule(Schedule{
+			At:           17 * time.Hour,
+			Location:     loadLocation("America/Toronto", -5*60*60),
+			BusinessDays: true,
+		}))
+		defaultExchange.Add(cnb.Source{}, WithSchedule(Schedule{
+			At:           15 * time.Hour,
+			Location:     loadLocation("Europe/Prague", 1*60*60),
+			BusinessDays: true,
+		}))
+		defaultExchange.Add(cbuae.Source{})
 	})
 
 	return defaultExchange
@@ -85,12 +111,8 @@ func OfflineExchange() *Exchange {
 			CacheDir:  DefaultCacheDir(),
 		}
 
-		offlineExchange.AddSource("ECB (offline)",
-			"data:text/csv;base64,"+base64.StdEncoding.EncodeToString([]byte(offline.HistoricalECBRates)),
-			ecb.Get)
-		offlineExchange.AddSource("BOC (offline)",
-			"data:text/csv;base64,"+base64.StdEncoding.EncodeToString([]byte(offline.HistoricalBOCRates)),
-			boc.Get)
+		offlineExchange.Add(ecb.Source{URL: dataURL([]byte(offline.HistoricalECBRates))}, WithName("ECB (offline)"))
+		offlineExchange.Add(boc.Source{URL: dataURL([]byte(offline.HistoricalBOCRates))}, WithName("BOC (offline)"))
 	})
 
 	return offlineExchange
@@ -115,15 +137,74 @@ func DefaultCacheDir() string {
 // OfflineExchange.
 type Exchange struct {
 	CacheLife time.Duration
-	CacheDir  string
+	// CacheDir is where the downloaded data is kept, unless Cache is set.
+	CacheDir string
+	// Cache stores the downloaded data. If nil, a DirCache in CacheDir is
+	// used. Must be set before the Exchange is first used.
+	Cache Cache
+	// HTTPClient is used for all downloads, unless a source was added with its
+	// own client or transport. If nil, a default client is used. Must be set
+	// before the Exchange is first used.
+	HTTPClient *http.Client
+	// Logger receives diagnostics: refresh errors, cache decisions and
+	// download timings. If nil, only errors are logged, using the standard log
+	// package. To silence all output, use a Logger that discards it.
+	Logger Logger
+	// Snapshot, if set, keeps the compiled exchange data in the Cache too, so
+	// that when all the sources are fresh in the cache, a new Exchange (e.g.
+	// in a new process) loads the snapshot instead of parsing their data.
+	Snapshot bool
+	// CrossRatesCacheDays, if positive, is how many days of CrossRates the
+	// Exchange keeps for each combination of options, until the exchange data
+	// is reloaded. Must be set before the Exchange is first used.
+	CrossRatesCacheDays int
 
-	mu           sync.RWMutex
-	graph        exchange.Graph
-	sources      []rateSource
-	lastDownload time.Time
+	// Held for the duration of a refresh, which may include downloads. It's a
+	// channel, so that waiting for it honours the caller's context. Acquire
+	// with lockRefresh before mu, never while holding it.
+	refreshOnce sync.Once
+	refreshMu   chan struct{}
+
+	mu      sync.RWMutex
+	graph   exchange.Graph
+	sources []*rateSource
+	// When the first source goes stale.
+	nextRefresh time.Time
+	// Number of running auto-refresh goroutines.
+	autoRefresh int
+	// Set if a cancelled refresh loaded new rates that the graph doesn't have
+	// yet. Guarded by the refresh lock.
+	rebuild bool
+	// The CrossRates of recently used days, by options. Reset whenever the
+	// graph is replaced.
+	crossRates map[string]*exchange.CrossRatesCache
+}
+
+// Logger receives diagnostic messages from an Exchange. It is satisfied by
+// *log.Logger. Sources are loaded in parallel, so Printf must be safe for
+// concurrent use.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+func (e *Exchange) errorf(format string, v ...interface{}) {
+	if e.Logger == nil {
+		log.Printf("ERROR: "+format, v...)
+		return
+	}
+	e.Logger.Printf("ERROR: "+format, v...)
+}
+
+func (e *Exchange) debugf(format string, v ...interface{}) {
+	if e.Logger != nil {
+		e.Logger.Printf(format, v...)
+	}
 }
 
 func (e *Exchange) String() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
 	sources := make([]string, len(e.sources))
 	for i, s := range e.sources {
 		sources[i] = s.name
@@ -135,38 +216,35 @@ func (e *Exchange) String() string {
 	return fmt.Sprintf("Exchange(%s, %d currencies)", strings.Join(sources, ", "), len(e.graph))
 }
 
-// Returns the mtime of the oldest on-disk cache file. Must be called with at
-// least a readlock, and will result in calls to stat(). Should only be used
-// once - subsequently lastDownload will be non-zero, and this codepath can be
-// avoided.
-func (e *Exchange) oldestCache() (time.Time, error) {
-	var oldest time.Time
-	for _, s := range e.sources {
-		t, err := s.lastReload()
-		if err != nil {
-			return time.Time{}, err
-		}
-		if t.Before(oldest) || oldest.IsZero() {
-			oldest = t
-		}
-	}
+// Returns the sources currently registered. Safe to call without any locks.
+func (e *Exchange) sourcesSnapshot() []*rateSource {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.sources[:len(e.sources):len(e.sources)]
+}
 
-	return oldest, nil
+func (e *Exchange) cache() Cache {
+	if e.Cache != nil {
+		return e.Cache
+	}
+	return DirCache(e.CacheDir)
 }
 
-func (e *Exchange) lockedRead() (exchange.Graph, error) {
+func (e *Exchange) lockedRead(ctx context.Context) (exchange.Graph, error) {
 	e.mu.RLock()
 	g := e.graph
-	lastDownload := e.lastDownload
+	nextRefresh := e.nextRefresh
+	background := e.autoRefresh > 0
 	e.mu.RUnlock()
 
 	// The graph is never modified, only replaced. If we have a pointer to it,
 	// it's safe to read without holding the lock. Do a check on our copied data
-	// to see if we need a refresh.
+	// to see if we need a refresh. (If a background goroutine is keeping the
+	// data fresh, then stale data is better than waiting for the network.)
 	now := time.Now()
-	if g == nil || lastDownload.Before(now.Add(e.CacheLife)) {
+	if g == nil || (!background && !now.Before(nextRefresh)) {
 		var err error
-		if g, err = e.maybeRefresh(now); err != nil {
+		if g, err = e.maybeRefresh(ctx, now); err != nil {
 			return nil, err
 		}
 	}
@@ -188,9 +266,18 @@ func (e *Exchange) lockedRead() (exchange.Graph, error) {
 // Use exchange.FullTrace to populate Result.Trace.
 //
 // Use exchange.AcceptOlderRate to extend the search to earlier data, if no
-// rates are available on the given day.
+// rates are available on the given day. Use exchange.AcceptNewerRate (e.g. for
+// the next business day's rate) or exchange.NearestRate to also search later
+// data. Result.Trace shows the days of the rates actually used.
 func (e *Exchange) Convert(from, to string, date time.Time, opts ...exchange.Option) (exchange.Result, error) {
-	g, err := e.lockedRead()
+	return e.ConvertContext(context.Background(), from, to, date, opts...)
+}
+
+// ConvertContext is like Convert, but any download required to answer the
+// query is bound to ctx. If ctx is cancelled or its deadline passes before the
+// exchange data is loaded, ConvertContext returns the context's error.
+func (e *Exchange) ConvertContext(ctx context.Context, from, to string, date time.Time, opts ...exchange.Option) (exchange.Result, error) {
+	g, err := e.lockedRead(ctx)
 	if err != nil {
 		return exchange.Result{}, err
 	}
@@ -198,6 +285,123 @@ func (e *Exchange) Convert(from, to string, date time.Time, opts ...exchange.Opt
 	return exchange.Convert(g, from, to, date, opts...)
 }
 
+// ConvertRange computes the exchange rate between the from and to currencies
+// on every day from start to end, inclusive. It's faster than calling Convert
+// for each day.
+//
+// Days without a rate (e.g. weekends, unless exchange.AcceptOlderRate allows
+// an older rate) have DatedResult.Err set to an error wrapping
+// exchange.ErrNotFound. The returned error is only for failures to load the
+// exchange data.
+func (e *Exchange) ConvertRange(from, to string, start, end time.Time, opts ...exchange.Option) ([]exchange.DatedResult, error) {
+	return e.ConvertRangeContext(context.Background(), from, to, start, end, opts...)
+}
+
+// ConvertRangeContext is like ConvertRange, but any download required to
+// answer the query is bound to ctx.
+func (e *Exchange) ConvertRangeContext(ctx context.Context, from, to string, start, end time.Time, opts ...exchange.Option) ([]exchange.DatedResult, error) {
+	g, err := e.lockedRead(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.ConvertRange(from, to, start, end, opts...), nil
+}
+
+// ConvertBatch runs many conversions at once, such as when importing invoices
+// in different currencies. The results are in the same order as the queries,
+// and each is the same as from Convert with the same options. Queries that
+// fail have BatchResult.Err set. The returned error is only for failures to
+// load the exchange data.
+func (e *Exchange) ConvertBatch(queries []exchange.Query, opts ...exchange.Option) ([]exchange.BatchResult, error) {
+	return e.ConvertBatchContext(context.Background(), queries, opts...)
+}
+
+// ConvertBatchContext is like ConvertBatch, but any download required to
+// answer the queries is bound to ctx.
+func (e *Exchange) ConvertBatchContext(ctx context.Context, queries []exchange.Query, opts ...exchange.Option) ([]exchange.BatchResult, error) {
+	g, err := e.lockedRead(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.ConvertBatch(queries, opts...), nil
+}
+
+// CrossRates converts between all pairs of available currencies on the day,
+// e.g. for a report. Conversions in the returned matrix are the same as from
+// Convert with the same options.
+//
+// If CrossRatesCacheDays is set, the matrices of the most recently used days
+// are shared between callers, so they must not be modified.
+func (e *Exchange) CrossRates(day time.Time, opts ...exchange.Option) (*exchange.CrossRates, error) {
+	return e.CrossRatesContext(context.Background(), day, opts...)
+}
+
+// CrossRatesContext is like CrossRates, but any download required to answer
+// the query is bound to ctx.
+func (e *Exchange) CrossRatesContext(ctx context.Context, day time.Time, opts ...exchange.Option) (*exchange.CrossRates, error) {
+	g, err := e.lockedRead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if e.CrossRatesCacheDays <= 0 {
+		return g.CrossRates(day, opts...), nil
+	}
+
+	// Several option types are integers, so the key needs their types too.
+	var key strings.Builder
+	for _, o := range opts {
+		fmt.Fprintf(&key, "%T(%#v);", o, o)
+	}
+	e.mu.Lock()
+	c, ok := e.crossRates[key.String()]
+	if !ok {
+		if e.crossRates == nil {
+			e.crossRates = make(map[string]*exchange.CrossRatesCache)
+		}
+		// Drop any cache to make room, rather than let callers with ever new
+		// options grow the map.
+		for k := range e.crossRates {
+			if len(e.crossRates) < maxCrossRatesOptions {
+				break
+			}
+			delete(e.crossRates, k)
+		}
+		c = exchange.NewCrossRatesCache(e.graph, e.CrossRatesCacheDays, opts...)
+		e.crossRates[key.String()] = c
+	}
+	e.mu.Unlock()
+
+	return c.CrossRates(day), nil
+}
+
+// maxCrossRatesOptions is how many combinations of options the Exchange keeps
+// CrossRates for.
+const maxCrossRatesOptions = 8
+
+// AverageRate computes the average exchange rate between the from and to
+// currencies over a period, such as exchange.Year(2023) for a yearly average
+// rate used in tax reporting.
+//
+// By default, the average is over the days with published rates. Pass
+// exchange.CalendarDays to average over every day of the period instead,
+// carrying rates forward over weekends and holidays.
+func (e *Exchange) AverageRate(from, to string, period exchange.Period, opts ...exchange.Option) (exchange.Average, error) {
+	return e.AverageRateContext(context.Background(), from, to, period, opts...)
+}
+
+// AverageRateContext is like AverageRate, but any download required to answer
+// the query is bound to ctx.
+func (e *Exchange) AverageRateContext(ctx context.Context, from, to string, period exchange.Period, opts ...exchange.Option) (exchange.Average, error) {
+	g, err := e.lockedRead(ctx)
+	if err != nil {
+		return exchange.Average{}, err
+	}
+
+	return g.AverageRate(from, to, period, opts...)
+}
+
 // Currencies returns the available currencies as a map of strings (a set).
 //
 // Note that technically nothing guarantees all of these currencies are mutually
@@ -205,12 +409,18 @@ func (e *Exchange) Convert(from, to string, date time.Time, opts ...exchange.Opt
 // are related to one of the major currencies, and all of them are
 // interconvertible.
 func (e *Exchange) Currencies() (map[string]bool, error) {
-	g, err := e.lockedRead()
+	return e.CurrenciesContext(context.Background())
+}
+
+// CurrenciesContext is like Currencies, but any download required to load the
+// exchange data is bound to ctx.
+func (e *Exchange) CurrenciesContext(ctx context.Context) (map[string]bool, error) {
+	g, err := e.lockedRead(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	res := make(map[string]bool, len(e.graph))
+	res := make(map[string]bool, len(g))
 	for c := range g {
 		res[c] = true
 	}
@@ -231,78 +441,311 @@ const (
 	FromRemoteSource
 )
 
-func (e *Exchange) maybeRefresh(now time.Time) (exchange.Graph, error) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+func (f Freshness) String() string {
+	switch f {
+	case FromMemory:
+		return "FromMemory"
+	case FromLocalCache:
+		return "FromLocalCache"
+	case FromRemoteSource:
+		return "FromRemoteSource"
+	default:
+		return "<invalid Freshness>"
+	}
+}
 
-	// Repeat the check that brought us here, this time holding the write lock.
-	// This ensures contention doesn't cause multiple reloads in quick sequence,
-	// and also lets us figure out the level of refresh required.
+// lockRefresh acquires the refresh lock, unless ctx is done first.
+func (e *Exchange) lockRefresh(ctx context.Context) error {
+	e.refreshOnce.Do(func() { e.refreshMu = make(chan struct{}, 1) })
+	select {
+	case e.refreshMu <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-	lvl := FromMemory
+func (e *Exchange) unlockRefresh() {
+	<-e.refreshMu
+}
 
-	if e.graph == nil {
-		// This is the first operation on a new Exchange. We need to check the
-		// age of on-disk caches.
-		t, err := e.oldestCache()
-		if err != nil {
+func (e *Exchange) maybeRefresh(ctx context.Context, now time.Time) (exchange.Graph, error) {
+	if err := e.lockRefresh(ctx); err != nil {
+		return nil, err
+	}
+	defer e.unlockRefresh()
+
+	// Repeat the check that brought us here, this time holding the refresh
+	// lock. This ensures contention doesn't cause multiple reloads in quick
+	// sequence, and also lets us figure out the level of refresh required for
+	// each source.
+	e.mu.RLock()
+	g := e.graph
+	e.mu.RUnlock()
+
+	cache := e.cache()
+	sources := e.sourcesSnapshot()
+	levels := make([]Freshness, len(sources))
+	refresh := false
+	for i, s := range sources {
+		// On the first operation on a new Exchange, this checks the age of the
+		// cache. (If there is no cache, the source counts as stale.)
+		if _, err := s.lastReload(cache); err != nil {
 			return nil, err
 		}
-		e.lastDownload = t
-		lvl = FromLocalCache
+
+		switch staleAt := s.staleAt(e.CacheLife); {
+		case !now.Before(staleAt):
+			levels[i] = FromRemoteSource
+		case g == nil || s.rates == nil:
+			// A source added after the first use, or not loaded since the
+			// snapshot, has never been parsed.
+			levels[i] = FromLocalCache
+		default:
+			continue
+		}
+		refresh = true
+		e.debugf("source %s: refresh level %v (last download %v)", s.name, levels[i], s.reloadTime)
+	}
+	if e.rebuild {
+		refresh = true
 	}
 
-	// Reload from source if the oldest cache is stale. (If we don't have
-	// on-disk cache, then lastDownload will be set to year 0, which will count
-	// as stale.)
-	if e.lastDownload.Before(now.Add(-e.CacheLife)) {
-		lvl = FromRemoteSource
+	if refresh && g == nil && e.Snapshot && !containsLevel(levels, FromRemoteSource) {
+		start := time.Now()
+		if err := e.loadSnapshot(cache, sources, now); err != nil {
+			e.debugf("not using the snapshot: %v", err)
+		} else {
+			e.debugf("loaded the snapshot in %v", time.Since(start))
+			refresh = false
+		}
 	}
 
-	if err := e.forceRefresh(lvl); err != nil {
-		return nil, err
+	if refresh {
+		if err := e.refresh(ctx, sources, levels); err != nil {
+			return nil, err
+		}
+	} else {
+		// Nothing is stale, but Add may have reset nextRefresh.
+		e.mu.Lock()
+		e.updateNextRefresh()
+		e.mu.Unlock()
 	}
 
-	// Return the graph while we're still holding the lock - this saves the call
-	// site having to reacquire the read lock.
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 	return e.graph, nil
 }
 
+func containsLevel(levels []Freshness, lvl Freshness) bool {
+	for _, l := range levels {
+		if l == lvl {
+			return true
+		}
+	}
+	return false
+}
+
 // ForceRefresh rebuilds the exchange data from the upstream source, which may
 // be online or otherwise remote to this machine.
 func (e *Exchange) ForceRefresh() error {
+	return e.RefreshContext(context.Background())
+}
+
+// RefreshContext is like ForceRefresh, but the downloads are bound to ctx. If
+// ctx is cancelled before all sources are loaded, RefreshContext returns the
+// context's error and the exchange keeps its previous data. (The sources that
+// did load are added at the next use.)
+func (e *Exchange) RefreshContext(ctx context.Context) error {
+	if err := e.lockRefresh(ctx); err != nil {
+		return err
+	}
+	defer e.unlockRefresh()
+	return e.forceRefresh(ctx, FromRemoteSource)
+}
+
+// StartAutoRefresh starts a goroutine that keeps the exchange data fresh in the
+// background, until ctx is done. Every interval, the goroutine checks whether
+// the data is older than CacheLife, and if so, downloads it again.
+//
+// While auto-refresh is running, Convert and Currencies never wait on the
+// network once the exchange data has been loaded: they use the current data
+// until the refreshed data replaces it. Only the first use of a new Exchange
+// may have to wait for a download.
+func (e *Exchange) StartAutoRefresh(ctx context.Context, interval time.Duration) {
 	e.mu.Lock()
-	defer e.mu.Unlock()
-	return e.forceRefresh(FromRemoteSource)
+	e.autoRefresh++
+	e.mu.Unlock()
+
+	go func() {
+		defer func() {
+			e.mu.Lock()
+			e.autoRefresh--
+			e.mu.Unlock()
+		}()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if _, err := e.maybeRefresh(ctx, time.Now()); err != nil && ctx.Err() == nil {
+				e.errorf("auto-refresh: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Origin specifies where the data of a source came from.
+type Origin int16
+
+const (
+	// No data is loaded from the source.
+	NotLoaded Origin = iota
+	// The data was loaded from the local cache.
+	Cached
+	// The data was downloaded during the most recent refresh.
+	Downloaded
+)
+
+func (o Origin) String() string {
+	switch o {
+	case NotLoaded:
+		return "NotLoaded"
+	case Cached:
+		return "Cached"
+	case Downloaded:
+		return "Downloaded"
+	default:
+		return "<invalid Origin>"
+	}
 }
 
-func (e *Exchange) forceRefresh(lvl Freshness) error {
+// SourceStatus describes the state of one source of exchange rates, as of its
+// most recent refresh. Obtain it from Exchange.Status.
+type SourceStatus struct {
+	// The name of the source. See Source.Name.
+	Name string
+	// Credits the publisher of the data. See Source.Attribution.
+	Attribution string
+	// When the source was last loaded, successfully or not.
+	LastAttempt time.Time
+	// When the source was last loaded without error.
+	LastSuccess time.Time
+	// The error from the last attempt, or nil if it succeeded. When a
+	// download fails, the exchange falls back to the cache, so Err can be set
+	// while Rates is non-zero.
+	Err error
+	// The number of rates currently loaded from the source.
+	Rates int
+	// The earliest and latest days covered by the loaded rates.
+	FirstDay, LastDay time.Time
+	// Where the loaded rates came from.
+	Origin Origin
+}
+
+func (st *SourceStatus) update(now time.Time, rates []exchange.Rate, origin Origin, err error) {
+	st.LastAttempt = now
+	st.Err = err
+	if err == nil {
+		st.LastSuccess = now
+	}
+	st.Rates = len(rates)
+	st.Origin = origin
+	st.FirstDay, st.LastDay = time.Time{}, time.Time{}
+	for _, r := range rates {
+		if st.FirstDay.IsZero() || r.Day.Before(st.FirstDay) {
+			st.FirstDay = r.Day
+		}
+		if r.Day.After(st.LastDay) {
+			st.LastDay = r.Day
+		}
+	}
+}
+
+// Status reports the state of each source of exchange rates, in the order the
+// sources were added. Sources that failed to refresh have a non-nil Err.
+//
+// Status doesn't trigger a refresh: before the Exchange is first used, all
+// sources are NotLoaded.
+func (e *Exchange) Status() []SourceStatus {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	res := make([]SourceStatus, len(e.sources))
+	for i, s := range e.sources {
+		res[i] = s.status
+	}
+	return res
+}
+
+// forceRefresh reloads all sources at the same level. Must be called with the
+// refresh lock held.
+func (e *Exchange) forceRefresh(ctx context.Context, lvl Freshness) error {
 	if lvl == FromMemory {
 		return nil
 	}
 
+	sources := e.sourcesSnapshot()
+	levels := make([]Freshness, len(sources))
+	for i := range levels {
+		levels[i] = lvl
+	}
+	return e.refresh(ctx, sources, levels)
+}
+
+// refresh reloads the sources at the given levels and replaces the graph. Must
+// be called with the refresh lock held. Holds the exchange lock only briefly,
+// to swap in the new data, so readers can use the old graph in the meantime.
+//
+// Sources at level FromMemory are not reloaded: the rates they produced in an
+// earlier refresh are reused. (Unless no rates were loaded yet, in which case
+// the source is loaded from the cache.)
+func (e *Exchange) refresh(ctx context.Context, sources []*rateSource, levels []Freshness) error {
 	now := time.Now()
-	var rates []exchange.Rate
 
 	// Loading the sources can start downloads over the network, so it makes
 	// sense to do it in parallel. (This appears to speed up a lot with multiple
 	// sources.)
-	var err error
-	ch := make(chan []exchange.Rate)
-	errCh := make(chan error)
+	type loadResult struct {
+		s      *rateSource
+		rates  []exchange.Rate
+		origin Origin
+		err    error
+	}
+	ch := make(chan loadResult)
 	var wg sync.WaitGroup
+	client := e.HTTPClient
+	cache := e.cache()
+
+	for i, s := range sources {
+		if levels[i] == FromMemory && s.rates != nil {
+			continue
+		}
 
-	for _, s := range e.sources {
 		wg.Add(1)
 		s := s
+		download := levels[i] == FromRemoteSource
 		go func() {
 			defer wg.Done()
 
-			r, err := s.reload(lvl == FromRemoteSource)
-			if err != nil {
-				errCh <- err
+			start := time.Now()
+			r, origin, err := s.reload(ctx, download, client, cache, now)
+			if download && ctx.Err() == nil {
+				if err == nil {
+					s.reloadTime, s.retryAt = now, time.Time{}
+				} else {
+					// Don't retry a failed download before the cache life
+					// passes, but don't wait for the next publication either.
+					s.retryAt = now.Add(s.life(e.CacheLife))
+				}
 			}
-			ch <- r
+			e.debugf("source %s: %d rates (%v) in %v", s.name, len(r), origin, time.Since(start))
+			ch <- loadResult{s: s, rates: r, origin: origin, err: err}
 		}()
 	}
 
@@ -311,127 +754,469 @@ func (e *Exchange) forceRefresh(lvl Freshness) error {
 		close(ch)
 	}()
 
-Loop:
-	for {
-		select {
-		case r, ok := <-ch:
-			if !ok {
-				break Loop
-			}
-			rates = append(rates, r...)
-		case err2 := <-errCh:
-			log.Printf("ERROR: refreshing exchange rate source: %v", err)
+	var results []loadResult
+	var err error
+	for r := range ch {
+		results = append(results, r)
+		if r.err != nil {
+			e.errorf("refreshing exchange rate source %s: %v", r.s.name, r.err)
 			if err == nil {
-				err = err2
+				err = r.err
+			}
+		}
+	}
+
+	// Don't replace good data with whatever was loaded before the caller gave
+	// up. The sources that did finish are already in the cache and count as
+	// fresh, though, so keep their rates for the next read to add.
+	if err := ctx.Err(); err != nil {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		for _, r := range results {
+			if r.err != nil {
+				r.s.status.LastAttempt = now
+				r.s.status.Err = err
+				continue
 			}
+			r.s.rates = r.rates
+			r.s.status.update(now, r.rates, r.origin, nil)
+			e.rebuild = true
+		}
+		if e.rebuild {
+			e.nextRefresh = time.Time{}
 		}
+		return err
 	}
 
 	// An error could have come from one of the load routines.
 	if err != nil {
-		log.Printf("ERROR: one or more exchange rate sources failed to load. First error: %v", err)
-		err = nil
+		e.errorf("one or more exchange rate sources failed to load. First error: %v", err)
 	}
+	complete := err == nil
 
+	total := 0
+	for _, r := range results {
+		r.s.rates = r.rates
+	}
+	for _, s := range sources {
+		total += len(s.rates)
+	}
+	rates := make([]exchange.Rate, 0, total)
+	for _, s := range sources {
+		rates = append(rates, s.rates...)
+	}
+
+	start := time.Now()
 	g, err := exchange.Compile(rates)
 	if err != nil {
 		return err
 	}
-	e.graph = g
+	e.debugf("compiled %d rates into %d currencies in %v", len(rates), len(g), time.Since(start))
 
-	if lvl == FromRemoteSource {
-		e.lastDownload = now
+	if e.Snapshot && complete {
+		if err := e.saveSnapshot(cache, sources, g); err != nil {
+			e.errorf("saving the snapshot: %v", err)
+		}
 	}
 
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, r := range results {
+		r.s.status.update(now, r.rates, r.origin, r.err)
+	}
+	e.graph = g
+	e.crossRates = nil
+	e.rebuild = false
+	e.updateNextRefresh()
 	return nil
 }
 
+// updateNextRefresh sets nextRefresh to when the first source goes stale. Must
+// be called with the exchange lock held.
+func (e *Exchange) updateNextRefresh() {
+	e.nextRefresh = time.Time{}
+	for _, s := range e.sources {
+		if t := s.staleAt(e.CacheLife); e.nextRefresh.IsZero() || t.Before(e.nextRefresh) {
+			e.nextRefresh = t
+		}
+	}
+}
+
+// Source is a source of exchange rates, usually a central bank. The packages
+// ecb, rba, boc, cbuae and cnb provide Sources for the banks they are named
+// after. Add sources to an Exchange with Add.
+//
+// The Exchange calls Fetch when the cached data goes stale, and Parse both on
+// freshly fetched data and on data loaded from the Cache.
+type Source interface {
+	// Name identifies the source in Status and in logs. It's also the key for
+	// the source's data in the Cache. (Override with WithName.)
+	Name() string
+	// Attribution credits the publisher of the data, e.g. "European Central
+	// Bank".
+	Attribution() string
+	// Fetch downloads the raw data covering the days from start to end. A zero
+	// start means as much history as available. Sources that only publish a
+	// single file can ignore the range. The client is never nil.
+	Fetch(ctx context.Context, client *http.Client, start, end time.Time) ([]byte, error)
+	// Parse extracts the rates from data previously returned by Fetch.
+	Parse(data []byte) ([]exchange.Rate, error)
+}
+
+// IncrementalSource is a Source that doesn't publish its full history in one
+// file, but e.g. one page per day. The Exchange keeps what such a source fetched
+// before, and only fetches the days since the last cached one (inclusive).
+//
+// Fetch is then called with a non-zero start, and must return data that Merge
+// can combine with the cached data. Fetch gets a zero start only when nothing
+// is cached yet.
+type IncrementalSource interface {
+	Source
+	// Merge returns the cached data updated with freshly fetched data. Where
+	// both have a rate for the same day, the fetched one should win.
+	Merge(cached, fetched []byte) ([]byte, error)
+}
+
+// GapFillingSource is an IncrementalSource that decides what to fetch from all
+// the cached days, rather than just the last one. This lets it fill gaps, e.g.
+// after the process was down for a while.
+type GapFillingSource interface {
+	IncrementalSource
+	// FetchMissing downloads the data that the cache lacks at time now, for
+	// Merge to add to it. Cached holds the days with cached rates in ascending
+	// order, and is empty if nothing is cached yet.
+	FetchMissing(ctx context.Context, client *http.Client, now time.Time, cached []time.Time) ([]byte, error)
+}
+
+// Add adds a new source of exchange rates. The new source is loaded the next
+// time the Exchange is used.
+//
+// Options control how the source is downloaded (see WithHTTPClient and
+// WithTransport) and when (see WithCacheLife and WithSchedule).
+func (e *Exchange) Add(src Source, opts ...SourceOption) {
+	s := &rateSource{
+		name: src.Name(),
+		src:  src,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.status = SourceStatus{Name: s.name, Attribution: src.Attribution()}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sources = append(e.sources, s)
+	// Make sure the next read picks up the new source.
+	e.nextRefresh = time.Time{}
+}
+
 // GetFunc is any function that loads and parses exchange rates from a URL. It
 // can be used with AddSource to register a new source of exchange rates.
+//
+// The URL may be a data URL: the Exchange passes cached data to the GetFunc
+// that way.
 type GetFunc func(url string) ([]exchange.Rate, error)
 
-var pathFriendlyChars = regexp.MustCompile(`[^a-zA-Z0-9]`)
+// dataURL encodes p as a base64 data URL, which GetFuncs can load via
+// internal.Fetch.
+func dataURL(p []byte) string {
+	return "data:;base64," + base64.StdEncoding.EncodeToString(p)
+}
+
+// getFuncSource adapts a URL and a GetFunc to the Source interface.
+type getFuncSource struct {
+	name string
+	url  string
+	f    GetFunc
+}
 
-// AddSource adds a new source of exchange rates. The caller must call
-// ForceReload if the Exchange has been recently used and has a local cache.
-func (e *Exchange) AddSource(name string, url string, getter GetFunc, fetchOpts ...internal.FetchOption) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+func (s getFuncSource) Name() string { return s.name }
 
-	cachePath := filepath.Join(e.CacheDir, "forex_"+pathFriendlyChars.ReplaceAllString(name, "_")+"_cache")
-	e.sources = append(e.sources, rateSource{
-		name:      name,
-		cachePath: cachePath,
-		sourceURL: url,
-		f:         getter,
-		fetchOpts: fetchOpts,
-	})
+func (s getFuncSource) Attribution() string { return s.name }
+
+func (s getFuncSource) Fetch(ctx context.Context, client *http.Client, start, end time.Time) ([]byte, error) {
+	return internal.FetchContext(ctx, s.url, internal.WithClient(client))
+}
+
+func (s getFuncSource) Parse(data []byte) ([]exchange.Rate, error) {
+	return s.f(dataURL(data))
+}
+
+// AddSource adds a new source of exchange rates, which is downloaded from a
+// fixed URL and parsed by the getter. It's a shorthand for Add with a simple
+// Source.
+//
+// The name also serves as the source's key in the Cache.
+func (e *Exchange) AddSource(name string, url string, getter GetFunc, opts ...SourceOption) {
+	e.Add(getFuncSource{name: name, url: url, f: getter}, opts...)
+}
+
+// URLFunc returns the URLs a source should download at a refresh at time now.
+// Cached holds the days that already have cached rates, in ascending order, so
+// the function can skip them.
+type URLFunc func(now time.Time, cached []time.Time) []string
+
+// DailyURLs returns a URLFunc for a source that publishes a page per business
+// day, at the URL returned by urlForDate (e.g. cnb.SourceURLForDate). The
+// URLFunc returns the URLs for the weekdays of the last window days that don't
+// have cached rates: at first all of them, later the new days and any gaps left
+// by downtime. Public holidays never get rates, so their pages are downloaded
+// again at every refresh, until they fall out of the window.
+func DailyURLs(urlForDate func(day time.Time) string, window int) URLFunc {
+	return func(now time.Time, cached []time.Time) []string {
+		var urls []string
+		seen := map[string]bool{}
+		for _, day := range internal.MissingBusinessDays(now, window, cached) {
+			if u := urlForDate(day); !seen[u] {
+				seen[u] = true
+				urls = append(urls, u)
+			}
+		}
+		return urls
+	}
+}
+
+// AddTemplateSource adds a source of exchange rates whose URLs change over
+// time, such as one page per day. At every refresh, urls decides what to
+// download, and getter parses each download. The rates accumulate in the
+// cache.
+//
+// A download that fails is skipped, and tried again at the next refresh if urls
+// still returns it. The refresh only fails if all of the downloads do.
+func (e *Exchange) AddTemplateSource(name string, urls URLFunc, getter GetFunc, opts ...SourceOption) {
+	e.Add(templateSource{name: name, urls: urls, f: getter}, opts...)
+}
+
+// templateSource adapts a URLFunc and a GetFunc to the GapFillingSource
+// interface. Its data is in the format of internal.EncodeRates.
+type templateSource struct {
+	name string
+	urls URLFunc
+	f    GetFunc
+}
+
+func (s templateSource) Name() string { return s.name }
+
+func (s templateSource) Attribution() string { return s.name }
+
+func (s templateSource) Fetch(ctx context.Context, client *http.Client, start, end time.Time) ([]byte, error) {
+	return s.FetchMissing(ctx, client, end, nil)
+}
+
+func (s templateSource) FetchMissing(ctx context.Context, client *http.Client, now time.Time, cached []time.Time) ([]byte, error) {
+	urls := s.urls(now, cached)
+	var rates []exchange.Rate
+	var firstErr error
+	ok := 0
+	for _, u := range urls {
+		raw, err := internal.FetchContext(ctx, u, internal.WithClient(client))
+		if err == nil {
+			var r []exchange.Rate
+			if r, err = s.f(dataURL(raw)); err == nil {
+				rates = append(rates, r...)
+				ok++
+				continue
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", u, err)
+		}
+	}
+	if len(urls) > 0 && ok == 0 {
+		return nil, firstErr
+	}
+	return internal.EncodeRates(rates), nil
+}
+
+func (s templateSource) Parse(data []byte) ([]exchange.Rate, error) {
+	return internal.DecodeRates(data, s.name)
+}
+
+func (s templateSource) Merge(cached, fetched []byte) ([]byte, error) {
+	old, err := s.Parse(cached)
+	if err != nil {
+		return nil, err
+	}
+	fresh, err := s.Parse(fetched)
+	if err != nil {
+		return nil, err
+	}
+	return internal.EncodeRates(append(old, fresh...)), nil
 }
 
 type rateSource struct {
-	name       string
-	cachePath  string
-	sourceURL  string
-	f          GetFunc
+	name      string
+	src       Source
+	client    *http.Client
+	transport http.RoundTripper
+	cacheLife time.Duration
+	schedule  *Schedule
+	// The last download, or the cache mtime before the first one. Guarded by
+	// the Exchange's refresh lock.
 	reloadTime time.Time
-	fetchOpts  []internal.FetchOption
+	// If the last download failed, when to try again. Guarded by the
+	// Exchange's refresh lock.
+	retryAt time.Time
+	// The rates loaded by the last refresh, so sources that are still fresh
+	// don't need to be parsed again. Guarded by the Exchange's refresh lock.
+	rates []exchange.Rate
+	// Guarded by the Exchange's mutex.
+	status SourceStatus
 }
 
-func (s *rateSource) lastReload() (time.Time, error) {
-	if s.reloadTime.IsZero() {
-		st, err := os.Stat(s.cachePath)
-		if os.IsNotExist(err) {
-			return time.Time{}, nil
-		}
+// staleAt returns when the data last downloaded from the source goes stale,
+// either because a new publication is due, or because it's older than the cache
+// life, or when a failed download should be retried.
+func (s *rateSource) staleAt(defaultLife time.Duration) time.Time {
+	if !s.retryAt.IsZero() {
+		return s.retryAt
+	}
+	if s.schedule != nil {
+		return s.schedule.next(s.reloadTime)
+	}
+	return s.reloadTime.Add(s.life(defaultLife))
+}
+
+// life returns the source's cache life, or the default one.
+func (s *rateSource) life(defaultLife time.Duration) time.Duration {
+	if s.cacheLife != 0 {
+		return s.cacheLife
+	}
+	return defaultLife
+}
 
+func (s *rateSource) lastReload(cache Cache) (time.Time, error) {
+	if s.reloadTime.IsZero() {
+		t, err := cache.ModTime(s.name)
 		if err != nil {
 			return time.Time{}, err
 		}
-
-		s.reloadTime = st.ModTime()
+		s.reloadTime = t
 	}
 
 	return s.reloadTime, nil
 }
 
-func (s *rateSource) reload(download bool) (rates []exchange.Rate, err error) {
-	if download {
-		// Ignore the error here - whether or not this worked, the thing that
-		// matters is the os.Create call.
-		os.MkdirAll(filepath.Dir(s.cachePath), 0740)
+// httpClient returns the client the source should download with: its own, or
+// the exchange-wide default, with the source's transport.
+func (s *rateSource) httpClient(def *http.Client) *http.Client {
+	c := def
+	if s.client != nil {
+		c = s.client
+	}
+	if c == nil {
+		c = &http.Client{}
+	}
+	if s.transport != nil {
+		c2 := *c
+		c2.Transport = s.transport
+		c = &c2
+	}
+	return c
+}
 
-		f, err := os.Create(s.cachePath)
+func (s *rateSource) loadCache(cache Cache) ([]exchange.Rate, error) {
+	data, err := cache.Get(s.name)
+	if err != nil {
+		return nil, err
+	}
+	return s.src.Parse(data)
+}
+
+// reload loads the rates from the cache, first refreshing the cache from the
+// source if download is set.
+//
+// If the download fails, reload falls back to the last good cache, returning
+// its rates together with the download error.
+func (s *rateSource) reload(ctx context.Context, download bool, client *http.Client, cache Cache, now time.Time) ([]exchange.Rate, Origin, error) {
+	if !download {
+		rates, err := s.loadCache(cache)
 		if err != nil {
-			return nil, err
+			return nil, NotLoaded, err
 		}
+		return rates, Cached, nil
+	}
 
-		// Best effort - lock the file on systems that support it. (This is
-		// cooperative, but the only code that should be touching this file is
-		// this code.) Systems that don't support flock (e.g. Windows) typically
-		// coordinate file access more strongly than UNIX, so things should even
-		// out.
-		syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
-		defer func() {
-			err2 := f.Sync()
-			if err == nil {
-				err = err2
-			}
-			syscall.Flock(int(f.Fd()), syscall.F_UNLCK)
-			// After Sync, Close has no reason to return error, but strange
-			// things do happen.
-			err2 = f.Close()
-			if err == nil {
-				err = err2
+	rates, err := s.download(ctx, client, cache, now)
+	if err == nil {
+		return rates, Downloaded, nil
+	}
+
+	old, err2 := s.loadCache(cache)
+	if err2 != nil {
+		// No usable cache either.
+		return nil, NotLoaded, err
+	}
+	return old, Cached, err
+}
+
+// download fetches and parses the source. Only if the parse yields some rates
+// does the new data replace the cache.
+//
+// An IncrementalSource only fetches the days from the last cached one (or the
+// missing days, for a GapFillingSource), and the new data is merged into the
+// cache.
+func (s *rateSource) download(ctx context.Context, client *http.Client, cache Cache, now time.Time) ([]exchange.Rate, error) {
+	inc, incremental := s.src.(IncrementalSource)
+	var days []time.Time
+	var cached []byte
+	if incremental {
+		// If the cache is missing or unreadable, start over.
+		if data, err := cache.Get(s.name); err == nil {
+			if rates, err := s.src.Parse(data); err == nil && len(rates) > 0 {
+				cached, days = data, rateDays(rates)
 			}
-		}()
-		data, err := internal.Fetch(s.sourceURL, s.fetchOpts...)
-		if err != nil {
-			return nil, err
 		}
-		if _, err := f.Write(data); err != nil {
-			return nil, err
+	}
+
+	var data []byte
+	var err error
+	if gf, ok := s.src.(GapFillingSource); ok {
+		data, err = gf.FetchMissing(ctx, s.httpClient(client), now, days)
+	} else {
+		var start time.Time
+		if len(days) > 0 {
+			start = days[len(days)-1]
+		}
+		data, err = s.src.Fetch(ctx, s.httpClient(client), start, now)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("downloading %s: %w", s.name, err)
+	}
+	if cached != nil {
+		if data, err = inc.Merge(cached, data); err != nil {
+			return nil, fmt.Errorf("merging %s download: %w", s.name, err)
 		}
 	}
 
-	return s.f(s.cachePath)
+	rates, err := s.src.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s download: %w", s.name, err)
+	}
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("parsing %s download: no rates found", s.name)
+	}
+
+	if err := cache.Put(s.name, data); err != nil {
+		return nil, fmt.Errorf("caching %s download: %w", s.name, err)
+	}
+	return rates, nil
+}
+
+// rateDays returns the days (in UTC) that have rates, in ascending order.
+func rateDays(rates []exchange.Rate) []time.Time {
+	seen := map[time.Time]bool{}
+	var days []time.Time
+	for _, r := range rates {
+		day := r.Day.UTC().Truncate(24 * time.Hour)
+		if !seen[day] {
+			seen[day] = true
+			days = append(days, day)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days
 }
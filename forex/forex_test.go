@@ -1,13 +1,23 @@
 package forex
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/wowsignal-io/go-forex/forex/ecb"
 	"github.com/wowsignal-io/go-forex/forex/exchange"
 	"github.com/wowsignal-io/go-forex/forex/internal"
 )
@@ -222,7 +232,7 @@ func TestConvert(t *testing.T) {
 				t.Errorf("%v.Convert(%q, %q, %v, %v) -> error %v (wanted error %v)", tc.exchange, tc.from, tc.to, tc.day, tc.opts, err, tc.wantErr)
 			}
 
-			if diff := cmp.Diff(tc.want, result, cmpopts.EquateApprox(0, 0.05), cmpopts.IgnoreFields(exchange.Rate{}, "Info")); diff != "" {
+			if diff := cmp.Diff(tc.want, result, cmpopts.EquateApprox(0, 0.05), cmpopts.IgnoreFields(exchange.Rate{}, "Info", "Decimal")); diff != "" {
 				t.Errorf("%v.Convert(%q, %q, %v, %v) -> (-) wanted vs. (+) got:\n%s", tc.exchange, tc.from, tc.to, tc.day, tc.opts, diff)
 			}
 		})
@@ -230,6 +240,867 @@ func TestConvert(t *testing.T) {
 
 }
 
+func TestConvertContextDeadline(t *testing.T) {
+	// The server never answers - it waits for the client to give up.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	e := &Exchange{CacheLife: DefaultCacheLife, CacheDir: t.TempDir()}
+	e.AddSource("ECB", srv.URL, ecb.Get)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := e.ConvertContext(ctx, "USD", "EUR", time.Date(2022, time.January, 4, 0, 0, 0, 0, time.UTC))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ConvertContext with a deadline -> error %v (wanted %v)", err, context.DeadlineExceeded)
+	}
+
+	if err := e.RefreshContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RefreshContext with an expired context -> error %v (wanted %v)", err, context.DeadlineExceeded)
+	}
+}
+
+func TestConvertContextWaitingForRefresh(t *testing.T) {
+	// The server answers once the test releases it.
+	started, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { close(started) })
+		<-release
+		http.ServeFile(w, r, "ecb/testdata/eurofxref-hist.zip")
+	}))
+	defer srv.Close()
+
+	e := &Exchange{CacheLife: DefaultCacheLife, CacheDir: t.TempDir()}
+	e.AddSource("ECB", srv.URL, ecb.Get)
+	day := time.Date(2012, time.July, 19, 0, 0, 0, 0, time.UTC)
+
+	// The first caller holds the refresh lock for the download.
+	first := make(chan error)
+	go func() {
+		_, err := e.Convert("USD", "EUR", day)
+		first <- err
+	}()
+	<-started
+
+	// The second caller gives up waiting for it at its own deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	second := make(chan error)
+	go func() {
+		_, err := e.ConvertContext(ctx, "USD", "EUR", day)
+		second <- err
+	}()
+	select {
+	case err := <-second:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("ConvertContext during another caller's download -> error %v (wanted %v)", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("ConvertContext during another caller's download -> still waiting after its deadline")
+		defer func() { <-second }()
+	}
+
+	close(release)
+	if err := <-first; err != nil {
+		t.Errorf("Convert holding the download -> %v", err)
+	}
+}
+
+// switchSource serves whatever data it's switched to. While that's empty, Fetch
+// waits for the context to end.
+type switchSource struct {
+	staticSource
+	name string
+	data *atomic.Value
+}
+
+func (s switchSource) Name() string { return s.name }
+
+func (s switchSource) Fetch(ctx context.Context, client *http.Client, start, end time.Time) ([]byte, error) {
+	data := s.data.Load().(string)
+	if data == "" {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return []byte(data), nil
+}
+
+func TestRefreshContextPartial(t *testing.T) {
+	var fast, slow atomic.Value
+	fast.Store("EUR USD 1.25 2024-05-10")
+	slow.Store("EUR CZK 25 2024-05-10")
+	cache := &MemoryCache{}
+	e := &Exchange{CacheLife: DefaultCacheLife, Cache: cache, Snapshot: true}
+	e.Add(switchSource{name: "Fast", data: &fast})
+	e.Add(switchSource{name: "Slow", data: &slow})
+	day := time.Date(2024, time.May, 10, 0, 0, 0, 0, time.UTC)
+	if _, err := e.Convert("USD", "EUR", day); err != nil {
+		t.Fatalf("Convert -> %v", err)
+	}
+
+	// The fast source loads its new rate, before the slow one times out.
+	fast.Store("EUR USD 1.5 2024-05-10")
+	slow.Store("")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := e.RefreshContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("RefreshContext with a slow source -> error %v (wanted %v)", err, context.DeadlineExceeded)
+	}
+
+	// The new rate is in the cache, so it must be used from now on, also by
+	// another Exchange loading the snapshot.
+	other := &Exchange{CacheLife: DefaultCacheLife, Cache: cache, Snapshot: true}
+	other.Add(switchSource{name: "Fast", data: &fast})
+	other.Add(switchSource{name: "Slow", data: &slow})
+	for i, e := range []*Exchange{e, other} {
+		if got, err := e.Convert("USD", "EUR", day); err != nil || got.Rate != 1/1.5 {
+			t.Errorf("Convert on exchange %d after a partial refresh -> %v, %v (wanted %v)", i, got.Rate, err, 1/1.5)
+		}
+	}
+}
+
+// countingTransport serves all requests from a local file and counts them. It's
+// safe to share between sources, which download concurrently.
+type countingTransport struct {
+	path  string
+	count int32
+}
+
+func (ct *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&ct.count, 1)
+	rec := httptest.NewRecorder()
+	http.ServeFile(rec, req, ct.path)
+	return rec.Result(), nil
+}
+
+func TestHTTPClient(t *testing.T) {
+	exchangeWide := &countingTransport{path: "ecb/testdata/eurofxref-hist.zip"}
+	perSource := &countingTransport{path: "ecb/testdata/eurofxref-hist.zip"}
+
+	e := &Exchange{
+		CacheLife:  DefaultCacheLife,
+		CacheDir:   t.TempDir(),
+		HTTPClient: &http.Client{Transport: exchangeWide},
+	}
+	e.AddSource("ECB", "https://ecb.invalid/eurofxref-hist.zip", ecb.Get)
+	e.AddSource("ECB (mirror)", "https://mirror.invalid/eurofxref-hist.zip", ecb.Get, WithTransport(perSource))
+
+	if _, err := e.Convert("USD", "EUR", time.Date(2012, time.July, 19, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("Convert -> %v", err)
+	}
+
+	if atomic.LoadInt32(&exchangeWide.count) != 1 {
+		t.Errorf("Exchange.HTTPClient was used for %d downloads (wanted 1)", atomic.LoadInt32(&exchangeWide.count))
+	}
+	if atomic.LoadInt32(&perSource.count) != 1 {
+		t.Errorf("WithTransport was used for %d downloads (wanted 1)", atomic.LoadInt32(&perSource.count))
+	}
+}
+
+func TestCacheFallback(t *testing.T) {
+	transport := &countingTransport{path: "ecb/testdata/eurofxref-hist.zip"}
+	e := &Exchange{
+		CacheLife:  DefaultCacheLife,
+		CacheDir:   t.TempDir(),
+		HTTPClient: &http.Client{Transport: transport},
+	}
+	e.AddSource("ECB", "https://ecb.invalid/eurofxref-hist.zip", ecb.Get)
+	day := time.Date(2012, time.July, 19, 0, 0, 0, 0, time.UTC)
+
+	want, err := e.Convert("USD", "EUR", day)
+	if err != nil {
+		t.Fatalf("Convert -> %v", err)
+	}
+
+	// The next download is a 404 page, which doesn't parse.
+	transport.path = "testdata/does-not-exist"
+	if err := e.ForceRefresh(); err != nil {
+		t.Fatalf("ForceRefresh -> %v", err)
+	}
+
+	got, err := e.Convert("USD", "EUR", day)
+	if err != nil {
+		t.Fatalf("Convert after a failed download -> %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Convert after a failed download -> (-) wanted vs. (+) got:\n%s", diff)
+	}
+
+	// A fresh exchange sharing the cache dir must still find the old cache.
+	e2 := &Exchange{CacheLife: DefaultCacheLife, CacheDir: e.CacheDir, HTTPClient: e.HTTPClient}
+	e2.AddSource("ECB", "https://ecb.invalid/eurofxref-hist.zip", ecb.Get)
+	if err := e2.ForceRefresh(); err != nil {
+		t.Fatalf("ForceRefresh -> %v", err)
+	}
+	if _, err := e2.Convert("USD", "EUR", day); err != nil {
+		t.Errorf("Convert from the last good cache -> %v", err)
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	// The server is down, but its error page happens to parse.
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		rec := httptest.NewRecorder()
+		http.ServeFile(rec, req, "ecb/testdata/eurofxref-hist.zip")
+		res := rec.Result()
+		res.StatusCode, res.Status = http.StatusServiceUnavailable, "503 Service Unavailable"
+		return res, nil
+	})
+	e := &Exchange{CacheLife: DefaultCacheLife, CacheDir: t.TempDir(), HTTPClient: &http.Client{Transport: transport}}
+	e.AddSource("ECB", "https://ecb.invalid/eurofxref-hist.zip", ecb.Get)
+
+	if _, err := e.Convert("USD", "EUR", time.Date(2012, time.July, 19, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Errorf("Convert with only an error page -> nil error")
+	}
+	if st := e.Status(); len(st) != 1 || st[0].Err == nil || !strings.Contains(st[0].Err.Error(), "503") {
+		t.Errorf("Status after an error page -> %+v (wanted the 503 error)", st)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	e := &Exchange{
+		CacheLife:  DefaultCacheLife,
+		CacheDir:   t.TempDir(),
+		HTTPClient: &http.Client{Transport: &countingTransport{path: "ecb/testdata/eurofxref-hist.zip"}},
+	}
+	e.AddSource("ECB", "https://ecb.invalid/eurofxref-hist.zip", ecb.Get)
+	e.AddSource("Broken", "https://broken.invalid/eurofxref-hist.zip", ecb.Get,
+		WithTransport(&countingTransport{path: "testdata/does-not-exist"}))
+
+	for _, st := range e.Status() {
+		if st.Origin != NotLoaded {
+			t.Errorf("Status() before first use -> %s is %v (wanted %v)", st.Name, st.Origin, NotLoaded)
+		}
+	}
+
+	if _, err := e.Convert("USD", "EUR", time.Date(2012, time.July, 19, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("Convert -> %v", err)
+	}
+
+	status := e.Status()
+	if len(status) != 2 {
+		t.Fatalf("Status() -> %d sources (wanted 2)", len(status))
+	}
+
+	if st := status[0]; st.Err != nil || st.Origin != Downloaded || st.Rates == 0 || st.LastSuccess.IsZero() {
+		t.Errorf("Status() -> %+v (wanted a successful download)", st)
+	} else if want := time.Date(1999, time.January, 4, 0, 0, 0, 0, time.UTC); !st.FirstDay.Equal(want) {
+		t.Errorf("Status() -> %s FirstDay=%v (wanted %v)", st.Name, st.FirstDay, want)
+	}
+
+	if st := status[1]; st.Err == nil || st.Origin != NotLoaded || st.Rates != 0 || !st.LastSuccess.IsZero() || st.LastAttempt.IsZero() {
+		t.Errorf("Status() -> %+v (wanted a failed download)", st)
+	}
+}
+
+type recordingLogger []string
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	*l = append(*l, fmt.Sprintf(format, v...))
+}
+
+func TestLogger(t *testing.T) {
+	var logger recordingLogger
+	e := &Exchange{
+		CacheLife:  DefaultCacheLife,
+		CacheDir:   t.TempDir(),
+		HTTPClient: &http.Client{Transport: &countingTransport{path: "testdata/does-not-exist"}},
+		Logger:     &logger,
+	}
+	e.AddSource("Broken", "https://broken.invalid/eurofxref-hist.zip", ecb.Get)
+
+	if _, err := e.Convert("USD", "EUR", time.Date(2012, time.July, 19, 0, 0, 0, 0, time.UTC)); !errors.Is(err, exchange.ErrNotFound) {
+		t.Fatalf("Convert with no data -> %v (wanted %v)", err, exchange.ErrNotFound)
+	}
+
+	for _, want := range []string{"refresh level FromRemoteSource", "ERROR: refreshing exchange rate source Broken"} {
+		found := false
+		for _, msg := range logger {
+			if strings.Contains(msg, want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Logger got %q (wanted a message containing %q)", logger, want)
+		}
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestAutoRefresh(t *testing.T) {
+	var downloads, hang int32
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if atomic.LoadInt32(&hang) != 0 {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		}
+		atomic.AddInt32(&downloads, 1)
+		rec := httptest.NewRecorder()
+		http.ServeFile(rec, req, "ecb/testdata/eurofxref-hist.zip")
+		return rec.Result(), nil
+	})
+
+	// The cache goes stale almost immediately, so every tick downloads.
+	e := &Exchange{
+		CacheLife:  time.Millisecond,
+		CacheDir:   t.TempDir(),
+		HTTPClient: &http.Client{Transport: transport},
+	}
+	e.AddSource("ECB", "https://ecb.invalid/eurofxref-hist.zip", ecb.Get)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e.StartAutoRefresh(ctx, 10*time.Millisecond)
+
+	deadline := time.Now().Add(30 * time.Second)
+	for atomic.LoadInt32(&downloads) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Auto-refresh made %d downloads (wanted at least 2)", atomic.LoadInt32(&downloads))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// From now on, downloads never finish. Convert must not wait for them.
+	atomic.StoreInt32(&hang, 1)
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	if _, err := e.Convert("USD", "EUR", time.Date(2012, time.July, 19, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("Convert during a background refresh -> %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Convert during a background refresh took %v", elapsed)
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	transport := &countingTransport{path: "ecb/testdata/eurofxref-hist.zip"}
+	cache := &MemoryCache{}
+	dir := filepath.Join(t.TempDir(), "unused")
+	day := time.Date(2012, time.July, 19, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		// The second exchange must find the first one's data still fresh.
+		e := &Exchange{
+			CacheLife:  DefaultCacheLife,
+			CacheDir:   dir,
+			Cache:      cache,
+			HTTPClient: &http.Client{Transport: transport},
+		}
+		e.AddSource("ECB", "https://ecb.invalid/eurofxref-hist.zip", ecb.Get)
+		if _, err := e.Convert("USD", "EUR", day); err != nil {
+			t.Fatalf("Convert -> %v", err)
+		}
+
+		if st := e.Status()[0]; i > 0 && st.Origin != Cached {
+			t.Errorf("Status() -> %s is %v (wanted %v)", st.Name, st.Origin, Cached)
+		}
+	}
+
+	if atomic.LoadInt32(&transport.count) != 1 {
+		t.Errorf("Made %d downloads (wanted 1)", atomic.LoadInt32(&transport.count))
+	}
+
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("MemoryCache touched CacheDir %s (stat error: %v)", dir, err)
+	}
+}
+
+func TestSourceCacheLife(t *testing.T) {
+	daily := &countingTransport{path: "ecb/testdata/eurofxref-hist.zip"}
+	stale := &countingTransport{path: "ecb/testdata/eurofxref-hist.zip"}
+	e := &Exchange{CacheLife: DefaultCacheLife, CacheDir: t.TempDir()}
+	e.AddSource("Daily", "https://daily.invalid/eurofxref-hist.zip", ecb.Get, WithTransport(daily))
+	e.AddSource("Stale", "https://stale.invalid/eurofxref-hist.zip", ecb.Get, WithTransport(stale), WithCacheLife(time.Nanosecond))
+
+	for i := 0; i < 3; i++ {
+		if _, err := e.Convert("USD", "EUR", time.Date(2012, time.July, 19, 0, 0, 0, 0, time.UTC)); err != nil {
+			t.Fatalf("Convert -> %v", err)
+		}
+	}
+
+	if atomic.LoadInt32(&daily.count) != 1 {
+		t.Errorf("Source with the default cache life downloaded %d times (wanted 1)", atomic.LoadInt32(&daily.count))
+	}
+	if atomic.LoadInt32(&stale.count) != 3 {
+		t.Errorf("Source with a short cache life downloaded %d times (wanted 3)", atomic.LoadInt32(&stale.count))
+	}
+}
+
+func TestScheduledRetry(t *testing.T) {
+	// The first download is a 404 page, which doesn't parse.
+	transport := &countingTransport{path: "testdata/does-not-exist"}
+	e := &Exchange{CacheLife: time.Nanosecond, CacheDir: t.TempDir(), HTTPClient: &http.Client{Transport: transport}}
+	// The next publication is never less than a few hours away.
+	now := time.Now().UTC()
+	e.AddSource("ECB", "https://ecb.invalid/eurofxref-hist.zip", ecb.Get, WithSchedule(Schedule{At: time.Duration(now.Hour()+12) % 24 * time.Hour}))
+
+	day := time.Date(2012, time.July, 19, 0, 0, 0, 0, time.UTC)
+	if _, err := e.Convert("USD", "EUR", day); err == nil {
+		t.Fatalf("Convert with a failed download -> nil error")
+	}
+
+	// A failed download is retried after the cache life, not at the next
+	// publication.
+	transport.path = "ecb/testdata/eurofxref-hist.zip"
+	if _, err := e.Convert("USD", "EUR", day); err != nil {
+		t.Fatalf("Convert after the cache life -> %v", err)
+	}
+	if atomic.LoadInt32(&transport.count) != 2 {
+		t.Errorf("Scheduled source downloaded %d times (wanted 2)", atomic.LoadInt32(&transport.count))
+	}
+}
+
+func TestIncrementalRefresh(t *testing.T) {
+	// The sources are parsed concurrently.
+	var mu sync.Mutex
+	parses := map[string]int{}
+	countingGet := func(name string) GetFunc {
+		return func(url string) ([]exchange.Rate, error) {
+			mu.Lock()
+			parses[name]++
+			mu.Unlock()
+			return ecb.Get(url)
+		}
+	}
+
+	transport := &countingTransport{path: "ecb/testdata/eurofxref-hist.zip"}
+	e := &Exchange{CacheLife: DefaultCacheLife, CacheDir: t.TempDir(), HTTPClient: &http.Client{Transport: transport}}
+	e.AddSource("Daily", "https://daily.invalid/eurofxref-hist.zip", countingGet("Daily"))
+	e.AddSource("Stale", "https://stale.invalid/eurofxref-hist.zip", countingGet("Stale"), WithCacheLife(time.Nanosecond))
+
+	for i := 0; i < 3; i++ {
+		if _, err := e.Convert("USD", "EUR", time.Date(2012, time.July, 19, 0, 0, 0, 0, time.UTC)); err != nil {
+			t.Fatalf("Convert -> %v", err)
+		}
+	}
+
+	if diff := cmp.Diff(map[string]int{"Daily": 1, "Stale": 3}, parses); diff != "" {
+		t.Errorf("Number of parses per source -> (-) wanted vs. (+) got:\n%s", diff)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	parses := 0
+	countingGet := func(url string) ([]exchange.Rate, error) {
+		parses++
+		return ecb.Get(url)
+	}
+	transport := &countingTransport{path: "ecb/testdata/eurofxref-hist.zip"}
+	cache := &MemoryCache{}
+	newExchange := func() *Exchange {
+		e := &Exchange{CacheLife: DefaultCacheLife, Cache: cache, HTTPClient: &http.Client{Transport: transport}, Snapshot: true}
+		e.AddSource("ECB", "https://ecb.invalid/eurofxref-hist.zip", countingGet)
+		return e
+	}
+	day := time.Date(2012, time.July, 19, 0, 0, 0, 0, time.UTC)
+
+	first := newExchange()
+	want, err := first.Convert("USD", "CZK", day, exchange.FullTrace)
+	if err != nil {
+		t.Fatalf("Convert -> %v", err)
+	}
+
+	// A new Exchange loads the snapshot instead of parsing the cached data.
+	second := newExchange()
+	got, err := second.Convert("USD", "CZK", day, exchange.FullTrace)
+	if err != nil {
+		t.Fatalf("Convert from the snapshot -> %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Convert from the snapshot -> (-) wanted vs. (+) got:\n%s", diff)
+	}
+	if parses != 1 || atomic.LoadInt32(&transport.count) != 1 {
+		t.Errorf("Parsed %d times and downloaded %d times (wanted 1 and 1)", parses, atomic.LoadInt32(&transport.count))
+	}
+	wantStatus, gotStatus := first.Status()[0], second.Status()[0]
+	if gotStatus.Origin != Cached || gotStatus.Rates != wantStatus.Rates || !gotStatus.LastDay.Equal(wantStatus.LastDay) {
+		t.Errorf("Status() from the snapshot -> %+v, wanted the same rates as %+v", gotStatus, wantStatus)
+	}
+
+	// Once the cached data changes, the snapshot is out of date.
+	data, err := cache.Get("ECB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if err := cache.Put("ECB", data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newExchange().Convert("USD", "CZK", day); err != nil {
+		t.Fatalf("Convert -> %v", err)
+	}
+	if parses != 2 {
+		t.Errorf("Parsed %d times after the cache changed (wanted 2)", parses)
+	}
+}
+
+// staticSource is a minimal Source, as a third party might write one. Its data
+// has one rate per line: "FROM TO RATE YYYY-MM-DD".
+type staticSource string
+
+func (staticSource) Name() string        { return "Static" }
+func (staticSource) Attribution() string { return "Test data" }
+
+func (s staticSource) Fetch(ctx context.Context, client *http.Client, start, end time.Time) ([]byte, error) {
+	return []byte(s), nil
+}
+
+func (staticSource) Parse(data []byte) ([]exchange.Rate, error) {
+	var rates []exchange.Rate
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid line %q", line)
+		}
+		rate, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, err
+		}
+		day, err := time.Parse("2006-01-02", fields[3])
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, exchange.Rate{From: fields[0], To: fields[1], Rate: rate, Day: day, Info: "Static"})
+	}
+	return rates, nil
+}
+
+func TestAdd(t *testing.T) {
+	e := &Exchange{CacheLife: DefaultCacheLife, Cache: &MemoryCache{}}
+	e.Add(staticSource("EUR CZK 25 2024-05-10\nEUR USD 1.25 2024-05-10"))
+
+	got, err := e.Convert("USD", "CZK", time.Date(2024, time.May, 10, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Convert -> %v", err)
+	}
+	if diff := cmp.Diff(exchange.Result{Rate: 20}, got, cmpopts.EquateApprox(0, 0.0001)); diff != "" {
+		t.Errorf("Convert -> (-) wanted vs. (+) got:\n%s", diff)
+	}
+
+	want := SourceStatus{Name: "Static", Attribution: "Test data", Rates: 2, Origin: Downloaded}
+	if diff := cmp.Diff(want, e.Status()[0], cmpopts.IgnoreFields(SourceStatus{}, "LastAttempt", "LastSuccess", "FirstDay", "LastDay")); diff != "" {
+		t.Errorf("Status() -> (-) wanted vs. (+) got:\n%s", diff)
+	}
+}
+
+func TestAddAfterFirstUse(t *testing.T) {
+	cache := &MemoryCache{}
+	e := &Exchange{CacheLife: DefaultCacheLife, Cache: cache}
+	e.Add(staticSource("EUR USD 1.25 2024-05-10"))
+	day := time.Date(2024, time.May, 10, 0, 0, 0, 0, time.UTC)
+	if _, err := e.Convert("USD", "EUR", day); err != nil {
+		t.Fatalf("Convert -> %v", err)
+	}
+
+	// The new source's cache entry is fresh, so it's loaded from the cache.
+	if err := cache.Put("Second", []byte("EUR CZK 25 2024-05-10")); err != nil {
+		t.Fatal(err)
+	}
+	e.Add(staticSource(""), WithName("Second"))
+	if _, err := e.Convert("USD", "CZK", day); err != nil {
+		t.Fatalf("Convert with the added source -> %v", err)
+	}
+	if got := e.Status()[1]; got.Origin != Cached || got.Rates != 1 {
+		t.Errorf("Status() of the added source -> %v with %d rates (wanted %v with 1)", got.Origin, got.Rates, Cached)
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.nextRefresh.IsZero() {
+		t.Errorf("nextRefresh after loading the added source -> zero")
+	}
+}
+
+// dailySource publishes one rate per day, and records the start of each Fetch.
+type dailySource struct {
+	staticSource
+	starts []time.Time
+}
+
+func (s *dailySource) Fetch(ctx context.Context, client *http.Client, start, end time.Time) ([]byte, error) {
+	s.starts = append(s.starts, start)
+	return []byte(fmt.Sprintf("EUR USD 1.25 %s\n", end.UTC().Format("2006-01-02"))), nil
+}
+
+func (s *dailySource) Merge(cached, fetched []byte) ([]byte, error) {
+	return append(append([]byte{}, cached...), fetched...), nil
+}
+
+func TestIncrementalSource(t *testing.T) {
+	cache := &MemoryCache{}
+	e := &Exchange{CacheLife: DefaultCacheLife, Cache: cache}
+	src := &dailySource{}
+	e.Add(src)
+
+	if err := e.ForceRefresh(); err != nil {
+		t.Fatalf("ForceRefresh -> %v", err)
+	}
+	if err := e.ForceRefresh(); err != nil {
+		t.Fatalf("ForceRefresh -> %v", err)
+	}
+
+	// The first Fetch has nothing cached, the second starts from the cached day.
+	if len(src.starts) != 2 || !src.starts[0].IsZero() || src.starts[1].IsZero() {
+		t.Errorf("Fetch called with starts %v, want a zero start, then the last cached day", src.starts)
+	}
+	data, err := cache.Get("Static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(data), "\n"); got != 2 {
+		t.Errorf("cached data has %d lines, want 2 (merged):\n%s", got, data)
+	}
+}
+
+func TestTemplateSource(t *testing.T) {
+	cache := &MemoryCache{}
+	e := &Exchange{CacheLife: DefaultCacheLife, Cache: cache}
+	// Each day's page is a data URL with a rate for that day.
+	urlForDate := func(day time.Time) string {
+		return dataURL([]byte(fmt.Sprintf("EUR USD 1.25 %s", day.Format("2006-01-02"))))
+	}
+	var gets int
+	e.AddTemplateSource("Daily", DailyURLs(urlForDate, 14), func(url string) ([]exchange.Rate, error) {
+		gets++
+		raw, err := internal.Fetch(url)
+		if err != nil {
+			return nil, err
+		}
+		return staticSource("").Parse(raw)
+	})
+
+	refresh := func() []exchange.Rate {
+		t.Helper()
+		gets = 0
+		if err := e.ForceRefresh(); err != nil {
+			t.Fatalf("ForceRefresh -> %v", err)
+		}
+		data, err := cache.Get("Daily")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rates, err := internal.DecodeRates(data, "Daily")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rates
+	}
+
+	rates := refresh()
+	if gets == 0 || gets != len(rates) {
+		t.Errorf("first refresh downloaded %d pages and cached %d rates, want one per weekday", gets, len(rates))
+	}
+	if refresh(); gets != 0 {
+		t.Errorf("second refresh downloaded %d pages, want 0", gets)
+	}
+
+	// Punch a hole in the cache, as if the process had been down that day.
+	if err := cache.Put("Daily", internal.EncodeRates(append(rates[:1:1], rates[2:]...))); err != nil {
+		t.Fatal(err)
+	}
+	if got := refresh(); gets != 1 || len(got) != len(rates) {
+		t.Errorf("refresh after a gap downloaded %d pages and cached %d rates, want 1 and %d", gets, len(got), len(rates))
+	}
+}
+
+func TestConvertRange(t *testing.T) {
+	e := &Exchange{CacheLife: DefaultCacheLife, Cache: &MemoryCache{}}
+	e.Add(staticSource("EUR USD 1.25 2024-05-10\nEUR USD 1.2 2024-05-13"))
+
+	got, err := e.ConvertRange("USD", "EUR", time.Date(2024, time.May, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, time.May, 13, 0, 0, 0, 0, time.UTC), exchange.AcceptOlderRate(1))
+	if err != nil {
+		t.Fatalf("ConvertRange -> %v", err)
+	}
+
+	// Friday's rate carries over to Saturday, but not to Sunday.
+	want := []float64{1 / 1.25, 1 / 1.25, 0, 1 / 1.2}
+	if len(got) != len(want) {
+		t.Fatalf("ConvertRange -> %d results, wanted %d", len(got), len(want))
+	}
+	for i, r := range got {
+		if wantErr := want[i] == 0; wantErr != errors.Is(r.Err, exchange.ErrNotFound) {
+			t.Errorf("ConvertRange -> err=%v on %v (wanted ErrNotFound: %v)", r.Err, r.Day, wantErr)
+		}
+		if diff := cmp.Diff(want[i], r.Rate, cmpopts.EquateApprox(0, 0.0001)); diff != "" {
+			t.Errorf("ConvertRange -> (-) wanted vs. (+) got on %v:\n%s", r.Day, diff)
+		}
+	}
+}
+
+func TestCrossRates(t *testing.T) {
+	e := &Exchange{CacheLife: DefaultCacheLife, Cache: &MemoryCache{}, CrossRatesCacheDays: 2}
+	e.Add(staticSource("EUR USD 1.25 2024-05-10\nEUR USD 1.2 2024-05-13"))
+	day := time.Date(2024, time.May, 10, 0, 0, 0, 0, time.UTC)
+
+	m, err := e.CrossRates(day)
+	if err != nil {
+		t.Fatalf("CrossRates -> %v", err)
+	}
+	if r, err := m.Convert("USD", "EUR"); err != nil || r.Rate != 1/1.25 {
+		t.Errorf("CrossRates(%v).Convert(USD, EUR) -> %v, %v (wanted %v)", day, r.Rate, err, 1/1.25)
+	}
+
+	// The matrix is kept for the same day and options, until a reload.
+	if again, _ := e.CrossRates(day); again != m {
+		t.Errorf("CrossRates(%v) again -> a new matrix (wanted the cached one)", day)
+	}
+	if older, _ := e.CrossRates(day, exchange.AcceptOlderRate(1)); older == m {
+		t.Errorf("CrossRates(%v, AcceptOlderRate(1)) -> the matrix without options", day)
+	}
+	if err := e.ForceRefresh(); err != nil {
+		t.Fatalf("ForceRefresh -> %v", err)
+	}
+	if reloaded, _ := e.CrossRates(day); reloaded == m {
+		t.Errorf("CrossRates(%v) after ForceRefresh -> the matrix from before", day)
+	}
+
+	// Options of different types with the same value have their own matrices.
+	sunday := time.Date(2024, time.May, 12, 0, 0, 0, 0, time.UTC)
+	for _, opts := range [][]exchange.Option{{exchange.AcceptOlderRate(1)}, {exchange.AcceptNewerRate(1)}} {
+		want, wantErr := e.Convert("USD", "EUR", sunday, opts...)
+		m, err := e.CrossRates(sunday, opts...)
+		if err != nil {
+			t.Fatalf("CrossRates(%v, %v) -> %v", sunday, opts, err)
+		}
+		if got, err := m.Convert("USD", "EUR"); got.Rate != want.Rate || (err == nil) != (wantErr == nil) {
+			t.Errorf("CrossRates(%v, %v).Convert(USD, EUR) -> %v, %v (wanted %v, %v)", sunday, opts, got.Rate, err, want.Rate, wantErr)
+		}
+	}
+
+	// Without CrossRatesCacheDays, every call computes a new matrix.
+	e = &Exchange{CacheLife: DefaultCacheLife, Cache: &MemoryCache{}}
+	e.Add(staticSource("EUR USD 1.25 2024-05-10"))
+	if first, _ := e.CrossRates(day); first == nil {
+		t.Errorf("CrossRates(%v) without CrossRatesCacheDays -> nil", day)
+	} else if second, _ := e.CrossRates(day); second == first {
+		t.Errorf("CrossRates(%v) without CrossRatesCacheDays -> a shared matrix", day)
+	}
+}
+
+func TestRound(t *testing.T) {
+	for _, tc := range []struct {
+		x    string
+		want map[RoundingMode]int64
+	}{
+		{"2.5", map[RoundingMode]int64{RoundHalfUp: 3, RoundHalfEven: 2, RoundHalfDown: 2, RoundTowardZero: 2, RoundAwayFromZero: 3}},
+		{"3.5", map[RoundingMode]int64{RoundHalfUp: 4, RoundHalfEven: 4, RoundHalfDown: 3, RoundTowardZero: 3, RoundAwayFromZero: 4}},
+		{"-2.5", map[RoundingMode]int64{RoundHalfUp: -3, RoundHalfEven: -2, RoundHalfDown: -2, RoundTowardZero: -2, RoundAwayFromZero: -3}},
+		{"2.51", map[RoundingMode]int64{RoundHalfUp: 3, RoundHalfEven: 3, RoundHalfDown: 3, RoundTowardZero: 2, RoundAwayFromZero: 3}},
+		{"2.000", map[RoundingMode]int64{RoundHalfUp: 2, RoundHalfEven: 2, RoundHalfDown: 2, RoundTowardZero: 2, RoundAwayFromZero: 2}},
+	} {
+		a, err := ParseAmount("XXX", tc.x)
+		if err != nil {
+			t.Fatalf("ParseAmount(%q) -> %v", tc.x, err)
+		}
+		for mode, want := range tc.want {
+			got, err := mode.Round(a.Rat(), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Int64() != want {
+				t.Errorf("%v.Round(%s, 0) -> %v, wanted %d", mode, tc.x, got, want)
+			}
+		}
+	}
+}
+
+func TestConvertAmount(t *testing.T) {
+	e := &Exchange{CacheLife: DefaultCacheLife, Cache: &MemoryCache{}}
+	e.Add(staticSource("EUR USD 1.25 2024-05-10\nEUR JPY 163.456 2024-05-10\nEUR KWD 0.3301 2024-05-10"))
+	day := time.Date(2024, time.May, 10, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		amount, to string
+		rounding   RoundingMode
+		want       string
+	}{
+		{"10.01", "USD", RoundHalfUp, "12.51 USD"},
+		// 0.125 is exactly half a cent.
+		{"0.10", "USD", RoundHalfUp, "0.13 USD"},
+		{"0.10", "USD", RoundHalfEven, "0.12 USD"},
+		{"10", "JPY", RoundHalfUp, "1635 JPY"},
+		{"10", "KWD", RoundHalfUp, "3.301 KWD"},
+		{"-10", "JPY", RoundTowardZero, "-1634 JPY"},
+	} {
+		amount, err := ParseAmount("EUR", tc.amount)
+		if err != nil {
+			t.Fatalf("ParseAmount(%q) -> %v", tc.amount, err)
+		}
+		got, err := e.ConvertAmount(amount, tc.to, day, tc.rounding, exchange.FullTrace)
+		if err != nil {
+			t.Fatalf("ConvertAmount(%v, %s) -> %v", amount, tc.to, err)
+		}
+		if got.Amount.String() != tc.want {
+			t.Errorf("ConvertAmount(%v, %s, %v) -> %v, wanted %s", amount, tc.to, tc.rounding, got.Amount, tc.want)
+		}
+		if len(got.Trace) != 1 {
+			t.Errorf("ConvertAmount(%v, %s) -> trace %v, wanted 1 rate", amount, tc.to, got.Trace)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	cet := time.FixedZone("CET", 60*60)
+	ecbSchedule := Schedule{At: 16 * time.Hour, Location: cet, BusinessDays: true}
+	for _, tc := range []struct {
+		comment  string
+		schedule Schedule
+		t        time.Time
+		want     time.Time
+	}{
+		{
+			comment:  "before publication",
+			schedule: ecbSchedule,
+			t:        time.Date(2024, time.May, 8, 9, 0, 0, 0, cet),
+			want:     time.Date(2024, time.May, 8, 16, 0, 0, 0, cet),
+		},
+		{
+			comment:  "after publication",
+			schedule: ecbSchedule,
+			t:        time.Date(2024, time.May, 8, 16, 0, 0, 0, cet),
+			want:     time.Date(2024, time.May, 9, 16, 0, 0, 0, cet),
+		},
+		{
+			comment:  "skip the weekend",
+			schedule: ecbSchedule,
+			t:        time.Date(2024, time.May, 10, 17, 0, 0, 0, cet),
+			want:     time.Date(2024, time.May, 13, 16, 0, 0, 0, cet),
+		},
+		{
+			comment:  "other time zone",
+			schedule: ecbSchedule,
+			t:        time.Date(2024, time.May, 8, 14, 30, 0, 0, time.UTC),
+			want:     time.Date(2024, time.May, 8, 16, 0, 0, 0, cet),
+		},
+		{
+			comment:  "every day, UTC",
+			schedule: Schedule{At: 6*time.Hour + 30*time.Minute},
+			t:        time.Date(2024, time.May, 11, 7, 0, 0, 0, time.UTC),
+			want:     time.Date(2024, time.May, 12, 6, 30, 0, 0, time.UTC),
+		},
+		{
+			comment:  "never downloaded",
+			schedule: ecbSchedule,
+			want:     time.Date(1, time.January, 1, 16, 0, 0, 0, cet),
+		},
+	} {
+		t.Run(tc.comment, func(t *testing.T) {
+			if got := tc.schedule.next(tc.t); !got.Equal(tc.want) {
+				t.Errorf("%+v.next(%v) -> %v (wanted %v)", tc.schedule, tc.t, got, tc.want)
+			}
+		})
+	}
+}
+
 func BenchmarkConvertRateOnly(b *testing.B) {
 	// Warm up the caches.
 	e := LiveExchange()
@@ -244,6 +1115,29 @@ func BenchmarkConvertRateOnly(b *testing.B) {
 	}
 }
 
+func BenchmarkConvertBatch(b *testing.B) {
+	// The same query as BenchmarkConvertRateOnly, for every day of a year, in
+	// both directions and to a few other currencies.
+	var queries []exchange.Query
+	for day := 0; day < 366; day++ {
+		t := time.Date(2012, time.January, 1+day, 0, 0, 0, 0, time.UTC)
+		for _, q := range [][2]string{{"USD", "CZK"}, {"CZK", "USD"}, {"USD", "EUR"}, {"USD", "JPY"}} {
+			queries = append(queries, exchange.Query{From: q[0], To: q[1], Day: t})
+		}
+	}
+
+	// Warm up the caches.
+	e := LiveExchange()
+	if _, err := e.ConvertBatch(queries, exchange.RateOnly); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.ConvertBatch(queries, exchange.RateOnly)
+	}
+}
+
 func BenchmarkConvertFullTrace(b *testing.B) {
 	// Warm up the caches.
 	e := LiveExchange()
@@ -257,3 +1151,18 @@ func BenchmarkConvertFullTrace(b *testing.B) {
 		e.Convert("USD", "CZK", time.Date(2012, time.July, 19, 0, 0, 0, 0, time.UTC), exchange.FullTrace)
 	}
 }
+
+func BenchmarkConvertRange(b *testing.B) {
+	// Warm up the caches.
+	e := LiveExchange()
+	start := time.Date(2012, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2012, time.December, 31, 0, 0, 0, 0, time.UTC)
+	if _, err := e.ConvertRange("USD", "CZK", start, end, exchange.RateOnly); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.ConvertRange("USD", "CZK", start, end, exchange.RateOnly)
+	}
+}
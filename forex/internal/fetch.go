@@ -2,6 +2,7 @@ package internal
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"fmt"
 	"io"
@@ -13,9 +14,32 @@ import (
 
 type FetchOption func(*http.Request, *http.Client) *http.Request
 
+// WithClient makes downloads use the configuration of the provided client
+// (transport, timeout, cookie jar and redirect policy). Options are applied in
+// order, so WithClient should come before any option that modifies the client.
+// A nil client leaves the default configuration.
+func WithClient(c *http.Client) FetchOption {
+	return func(req *http.Request, client *http.Client) *http.Request {
+		if c != nil {
+			*client = *c
+		}
+		return nil
+	}
+}
+
 // Fetch returns the given resource data, handling URLs (including simple data
 // URLs), as well as filesystem paths.
 func Fetch(resource string, opts ...FetchOption) ([]byte, error) {
+	return FetchContext(context.Background(), resource, opts...)
+}
+
+// FetchContext is like Fetch, but the download is bound to ctx: cancelling the
+// context aborts the HTTP request.
+func FetchContext(ctx context.Context, resource string, opts ...FetchOption) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	u, err := url.Parse(resource)
 	if err != nil {
 		return nil, err
@@ -23,7 +47,7 @@ func Fetch(resource string, opts ...FetchOption) ([]byte, error) {
 
 	switch u.Scheme {
 	case "http", "https":
-		return download(resource, opts...)
+		return download(ctx, resource, opts...)
 	case "data":
 		return decode(u.Opaque)
 	case "":
@@ -33,8 +57,8 @@ func Fetch(resource string, opts ...FetchOption) ([]byte, error) {
 	}
 }
 
-func download(uri string, opts ...FetchOption) ([]byte, error) {
-	req, err := http.NewRequest("GET", uri, nil)
+func download(ctx context.Context, uri string, opts ...FetchOption) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -52,6 +76,10 @@ func download(uri string, opts ...FetchOption) ([]byte, error) {
 	}
 
 	defer resp.Body.Close()
+	// Don't let an error page pass for data.
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", uri, resp.Status)
+	}
 
 	var b bytes.Buffer
 	_, err = io.Copy(&b, resp.Body)
@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wowsignal-io/go-forex/forex/exchange"
+)
+
+// ratesHeader starts the simple CSV format used to cache rates that were
+// assembled from several downloads.
+const ratesHeader = "day,from,to,rate\n"
+
+// IsEncodedRates reports whether raw is in the format written by EncodeRates.
+func IsEncodedRates(raw []byte) bool {
+	return bytes.HasPrefix(raw, []byte(ratesHeader))
+}
+
+// EncodeRates writes the rates as CSV, sorted by day and currency. Of rates for
+// the same day and currency pair, the last one is kept. The rate is written as
+// its Decimal, if set. The Info field is not stored.
+func EncodeRates(rates []exchange.Rate) []byte {
+	type key struct {
+		day      string
+		from, to string
+	}
+	latest := make(map[key]string, len(rates))
+	for _, r := range rates {
+		decimal := r.Decimal
+		if decimal == "" {
+			decimal = strconv.FormatFloat(r.Rate, 'g', -1, 64)
+		}
+		latest[key{r.Day.UTC().Format("2006-01-02"), r.From, r.To}] = decimal
+	}
+	keys := make([]key, 0, len(latest))
+	for k := range latest {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].day != keys[j].day {
+			return keys[i].day < keys[j].day
+		}
+		if keys[i].from != keys[j].from {
+			return keys[i].from < keys[j].from
+		}
+		return keys[i].to < keys[j].to
+	})
+
+	var buf bytes.Buffer
+	buf.WriteString(ratesHeader)
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "%s,%s,%s,%s\n", k.day, k.from, k.to, latest[k])
+	}
+	return buf.Bytes()
+}
+
+// DecodeRates reads rates written by EncodeRates, setting their Info field. The
+// Decimal field is set to the rate as written.
+func DecodeRates(raw []byte, info string) ([]exchange.Rate, error) {
+	if !IsEncodedRates(raw) {
+		return nil, fmt.Errorf("missing header %q", strings.TrimSpace(ratesHeader))
+	}
+	lines := strings.Split(strings.TrimSpace(string(raw[len(ratesHeader):])), "\n")
+	rates := make([]exchange.Rate, 0, len(lines))
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("line %d: expected 4 fields, got %d", i+2, len(fields))
+		}
+		day, err := time.Parse("2006-01-02", fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+2, err)
+		}
+		rate, err := strconv.ParseFloat(fields[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+2, err)
+		}
+		r := exchange.Rate{Info: info, Day: day, From: fields[1], To: fields[2], Rate: rate}
+		if rate > 0 {
+			r.Decimal = fields[3]
+		}
+		rates = append(rates, r)
+	}
+	return rates, nil
+}
+
+// MissingBusinessDays returns the weekdays (in UTC) of the window of days
+// ending with now that are not in cached, in ascending order.
+func MissingBusinessDays(now time.Time, window int, cached []time.Time) []time.Time {
+	have := make(map[time.Time]bool, len(cached))
+	for _, day := range cached {
+		have[day.UTC().Truncate(24*time.Hour)] = true
+	}
+
+	today := now.UTC().Truncate(24 * time.Hour)
+	var missing []time.Time
+	for day := today.AddDate(0, 0, 1-window); !day.After(today); day = day.AddDate(0, 0, 1) {
+		if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday || have[day] {
+			continue
+		}
+		missing = append(missing, day)
+	}
+	return missing
+}
@@ -0,0 +1,27 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMissingBusinessDays(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, time.May, d, 0, 0, 0, 0, time.UTC) }
+	// Friday, with the window reaching back to Monday.
+	now := day(10).Add(15 * time.Hour)
+
+	got := MissingBusinessDays(now, 5, []time.Time{day(8)})
+	want := []time.Time{day(6), day(7), day(9), day(10)}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("MissingBusinessDays(%v, 5, [May 8]) -> (-) wanted vs. (+) got:\n%s", now, diff)
+	}
+
+	if got := MissingBusinessDays(now, 1, nil); !cmp.Equal(got, []time.Time{day(10)}) {
+		t.Errorf("MissingBusinessDays(%v, 1, nil) -> %v (wanted only today)", now, got)
+	}
+	if got := MissingBusinessDays(now, 0, nil); len(got) != 0 {
+		t.Errorf("MissingBusinessDays(%v, 0, nil) -> %v (wanted none)", now, got)
+	}
+}
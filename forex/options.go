@@ -0,0 +1,108 @@
+package forex
+
+import (
+	"net/http"
+	"time"
+)
+
+// SourceOption is an option for Add and AddSource.
+type SourceOption func(*rateSource)
+
+// WithName overrides the name of the source, which is also its key in the
+// Cache. Use it to add the same kind of Source twice.
+func WithName(name string) SourceOption {
+	return func(s *rateSource) {
+		s.name = name
+	}
+}
+
+// WithHTTPClient makes the source download using the provided client, instead
+// of Exchange.HTTPClient.
+func WithHTTPClient(c *http.Client) SourceOption {
+	return func(s *rateSource) {
+		s.client = c
+	}
+}
+
+// WithTransport makes the source download using the provided RoundTripper,
+// e.g. to route through a proxy or to serve responses from an httptest server.
+// The rest of the client configuration (such as the timeout) is unchanged.
+func WithTransport(rt http.RoundTripper) SourceOption {
+	return func(s *rateSource) {
+		s.transport = rt
+	}
+}
+
+// WithCacheLife overrides Exchange.CacheLife for the source.
+func WithCacheLife(d time.Duration) SourceOption {
+	return func(s *rateSource) {
+		s.cacheLife = d
+	}
+}
+
+// WithSchedule makes the exchange refresh the source only after its next
+// publication is due, ignoring the cache life.
+func WithSchedule(sch Schedule) SourceOption {
+	return func(s *rateSource) {
+		s.schedule = &sch
+	}
+}
+
+// Schedule specifies when a source publishes new data, e.g. every business day
+// at 16:00 CET. A source with a schedule is refreshed once after every
+// publication.
+//
+// If a publication runs late, the refresh just after the scheduled time
+// downloads the old data again, and the new data is only picked up after the
+// next publication. Leave some margin in At.
+type Schedule struct {
+	// Time of day of the publication, as the offset from midnight.
+	At time.Duration
+	// The time zone in which At is specified. If nil, UTC is used.
+	Location *time.Location
+	// If set, the source doesn't publish on Saturdays and Sundays.
+	BusinessDays bool
+}
+
+// publication returns the publication time on the given day, if there is one.
+func (sch *Schedule) publication(year int, month time.Month, day int) (time.Time, bool) {
+	loc := sch.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	// Using time.Date with the hour and minute (rather than adding At to
+	// midnight) keeps the publication at the same wall-clock time across DST
+	// changes.
+	t := time.Date(year, month, day, int(sch.At/time.Hour), int(sch.At%time.Hour/time.Minute), 0, 0, loc)
+	if sch.BusinessDays && (t.Weekday() == time.Saturday || t.Weekday() == time.Sunday) {
+		return t, false
+	}
+	return t, true
+}
+
+// next returns the first publication strictly after t.
+func (sch *Schedule) next(t time.Time) time.Time {
+	loc := sch.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	local := t.In(loc)
+	// The loop can skip at most a weekend.
+	for i := 0; i < 4; i++ {
+		d := local.AddDate(0, 0, i)
+		if p, ok := sch.publication(d.Year(), d.Month(), d.Day()); ok && p.After(t) {
+			return p
+		}
+	}
+	panic("unreachable: no publication within 4 days")
+}
+
+// loadLocation loads the named time zone, falling back to a fixed UTC offset
+// (in seconds) on systems without a time zone database.
+func loadLocation(name string, offset int) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.FixedZone(name, offset)
+	}
+	return loc
+}
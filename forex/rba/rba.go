@@ -8,9 +8,11 @@ package rba
 
 import (
 	"bytes"
+	"context"
 	"encoding/csv"
 	"fmt"
 	"io"
+	"net/http"
 	"strconv"
 	"time"
 
@@ -96,11 +98,12 @@ func parse(r io.Reader) ([]exchange.Rate, error) {
 			}
 
 			result = append(result, exchange.Rate{
-				From: "AUD",
-				To:   currency,
-				Day:  t,
-				Rate: x,
-				Info: "RBA",
+				From:    "AUD",
+				To:      currency,
+				Day:     t,
+				Rate:    x,
+				Info:    "RBA",
+				Decimal: record[field],
 			})
 		}
 	}
@@ -111,6 +114,32 @@ func Get(uri string) ([]exchange.Rate, error) {
 	if err != nil {
 		return nil, err
 	}
+	return Source{}.Parse(raw)
+}
+
+// Source provides the RBA rates to forex.Exchange. The zero value downloads
+// from DefaultRBASource.
+type Source struct {
+	// Where to download the CSV from, if not DefaultRBASource. Can also be a
+	// file path or a data URL.
+	URL string
+}
+
+func (Source) Name() string { return "RBA" }
+
+func (Source) Attribution() string { return "Reserve Bank of Australia" }
+
+// Fetch downloads the whole table - the RBA doesn't publish smaller ranges in
+// the same format.
+func (s Source) Fetch(ctx context.Context, client *http.Client, start, end time.Time) ([]byte, error) {
+	uri := s.URL
+	if uri == "" {
+		uri = DefaultRBASource
+	}
+	return internal.FetchContext(ctx, uri, internal.WithClient(client))
+}
+
+func (Source) Parse(raw []byte) ([]exchange.Rate, error) {
 	return parse(bytes.NewReader(raw))
 }
 
@@ -0,0 +1,122 @@
+package forex
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"github.com/wowsignal-io/go-forex/forex/exchange"
+)
+
+// snapshotKey is the Cache key of the compiled graph. (See Exchange.Snapshot.)
+const snapshotKey = "Compiled graph"
+
+// snapshotVersion changes whenever the parsers change their output, so that
+// snapshots compiled by an older version are ignored.
+const snapshotVersion = 1
+
+// snapshot is the compiled graph, together with what's needed to check that
+// it's still up to date with the cache.
+type snapshot struct {
+	Version int
+	Sources []snapshotSource
+	// The graph, as encoded by exchange.Graph.MarshalBinary.
+	Graph []byte
+}
+
+type snapshotSource struct {
+	Name string
+	// The ModTime of the source's cache entry that the graph was compiled
+	// from.
+	ModTime time.Time
+	// For SourceStatus.
+	Rates             int
+	FirstDay, LastDay time.Time
+}
+
+// saveSnapshot stores the graph compiled from the rates of the sources in the
+// cache. Must be called with the refresh lock held, after every source loaded
+// without error.
+func (e *Exchange) saveSnapshot(cache Cache, sources []*rateSource, g exchange.Graph) error {
+	snap := snapshot{Version: snapshotVersion, Sources: make([]snapshotSource, len(sources))}
+	for i, s := range sources {
+		modTime, err := cache.ModTime(s.name)
+		if err != nil {
+			return err
+		}
+		if modTime.IsZero() {
+			return fmt.Errorf("source %s isn't cached", s.name)
+		}
+		var st SourceStatus
+		st.update(time.Time{}, s.rates, Cached, nil)
+		snap.Sources[i] = snapshotSource{Name: s.name, ModTime: modTime, Rates: st.Rates, FirstDay: st.FirstDay, LastDay: st.LastDay}
+	}
+
+	var err error
+	if snap.Graph, err = g.MarshalBinary(); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&snap); err != nil {
+		return err
+	}
+	return cache.Put(snapshotKey, buf.Bytes())
+}
+
+// loadSnapshot replaces the graph with the snapshot in the cache, if the cache
+// entries of all the sources are the same as when the snapshot was taken. Must
+// be called with the refresh lock held.
+//
+// The sources' rates aren't loaded: a later refresh parses them from the
+// cache, if needed.
+func (e *Exchange) loadSnapshot(cache Cache, sources []*rateSource, now time.Time) error {
+	data, err := cache.Get(snapshotKey)
+	if err != nil {
+		return err
+	}
+	var snap snapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snap); err != nil {
+		return err
+	}
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("snapshot version %d, want %d", snap.Version, snapshotVersion)
+	}
+	if len(snap.Sources) != len(sources) {
+		return fmt.Errorf("snapshot has %d sources, want %d", len(snap.Sources), len(sources))
+	}
+	for i, s := range sources {
+		modTime, err := cache.ModTime(s.name)
+		if err != nil {
+			return err
+		}
+		if snap.Sources[i].Name != s.name || !snap.Sources[i].ModTime.Equal(modTime) {
+			return fmt.Errorf("source %s changed since the snapshot", s.name)
+		}
+	}
+
+	var g exchange.Graph
+	if err := g.UnmarshalBinary(snap.Graph); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, s := range sources {
+		src := snap.Sources[i]
+		s.status = SourceStatus{
+			Name:        s.status.Name,
+			Attribution: s.status.Attribution,
+			LastAttempt: now,
+			LastSuccess: now,
+			Rates:       src.Rates,
+			FirstDay:    src.FirstDay,
+			LastDay:     src.LastDay,
+			Origin:      Cached,
+		}
+	}
+	e.graph = g
+	e.crossRates = nil
+	e.updateNextRefresh()
+	return nil
+}